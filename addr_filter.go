@@ -0,0 +1,260 @@
+package caskadht
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// AddrFilter decides which provider addrs are included in lookup results.
+// See: WithAddrFilter.
+type AddrFilter interface {
+	// Allow checks whether the given addr is included in lookup results.
+	Allow(multiaddr.Multiaddr) bool
+}
+
+// AddrFilterFunc adapts a function to AddrFilter.
+type AddrFilterFunc func(multiaddr.Multiaddr) bool
+
+// Allow calls f(addr).
+func (f AddrFilterFunc) Allow(addr multiaddr.Multiaddr) bool { return f(addr) }
+
+// PubliclyDialableAddrFilter allows only publicly dialable addrs, and is the default AddrFilter.
+// See: IsPubliclyDialableAddr.
+var PubliclyDialableAddrFilter AddrFilter = AddrFilterFunc(IsPubliclyDialableAddr)
+
+// Transports recognised by NewTransportAddrFilter.
+const (
+	TransportTCP          = "tcp"
+	TransportWS           = "ws"
+	TransportWSS          = "wss"
+	TransportQUIC         = "quic"
+	TransportQUICv1       = "quic-v1"
+	TransportWebTransport = "webtransport"
+	TransportWebRTCDirect = "webrtc-direct"
+	TransportWebRTC       = "webrtc"
+)
+
+// RelayAddrPolicy specifies how relayed addrs, i.e. addrs containing /p2p-circuit, are filtered.
+type RelayAddrPolicy string
+
+const (
+	// RelayAddrsAllow allows relayed addrs, subject to the filtering of the relay addr.
+	RelayAddrsAllow RelayAddrPolicy = "allow"
+	// RelayAddrsDeny excludes all relayed addrs.
+	RelayAddrsDeny RelayAddrPolicy = "deny"
+	// RelayAddrsOnly excludes all addrs that are not relayed.
+	RelayAddrsOnly RelayAddrPolicy = "only"
+)
+
+// AllAddrFilters returns an AddrFilter that allows an addr only if all the given filters allow it.
+func AllAddrFilters(filters ...AddrFilter) AddrFilter {
+	return AddrFilterFunc(func(addr multiaddr.Multiaddr) bool {
+		for _, f := range filters {
+			if !f.Allow(addr) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// AnyAddrFilter returns an AddrFilter that allows an addr if any of the given filters allows it.
+func AnyAddrFilter(filters ...AddrFilter) AddrFilter {
+	return AddrFilterFunc(func(addr multiaddr.Multiaddr) bool {
+		for _, f := range filters {
+			if f.Allow(addr) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// NewCIDRAddrFilter returns an AddrFilter that excludes IP addrs within any of the deny ranges,
+// and if any allow ranges are given, IP addrs that are not within any of them. Addrs that are not
+// IP addrs, e.g. DNS addrs, are allowed.
+func NewCIDRAddrFilter(allow, deny []string) (AddrFilter, error) {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return AddrFilterFunc(func(addr multiaddr.Multiaddr) bool {
+		ip, err := manet.ToIP(addr)
+		if err != nil {
+			return true
+		}
+		if containsIP(denyNets, ip) {
+			return false
+		}
+		return len(allowNets) == 0 || containsIP(allowNets, ip)
+	}), nil
+}
+
+// NewTransportAddrFilter returns an AddrFilter that allows only the addrs of the given transports.
+// For relayed addrs the transport of the relay addr is considered, except for WebRTC addrs, i.e.
+// addrs ending in /p2p-circuit/webrtc, which are only ever relayed and are of TransportWebRTC.
+func NewTransportAddrFilter(transports ...string) (AddrFilter, error) {
+	allowed := make(map[string]struct{}, len(transports))
+	for _, t := range transports {
+		switch t {
+		case TransportTCP, TransportWS, TransportWSS, TransportQUIC, TransportQUICv1,
+			TransportWebTransport, TransportWebRTCDirect, TransportWebRTC:
+			allowed[t] = struct{}{}
+		default:
+			return nil, fmt.Errorf("unknown transport: %s", t)
+		}
+	}
+	return AddrFilterFunc(func(addr multiaddr.Multiaddr) bool {
+		_, found := allowed[addrTransport(addr)]
+		return found
+	}), nil
+}
+
+// NewRelayAddrFilter returns an AddrFilter that applies the given policy to relayed addrs.
+func NewRelayAddrFilter(policy RelayAddrPolicy) (AddrFilter, error) {
+	switch policy {
+	case RelayAddrsAllow:
+		return AddrFilterFunc(func(multiaddr.Multiaddr) bool { return true }), nil
+	case RelayAddrsDeny:
+		return AddrFilterFunc(func(addr multiaddr.Multiaddr) bool { return !isRelayAddr(addr) }), nil
+	case RelayAddrsOnly:
+		return AddrFilterFunc(isRelayAddr), nil
+	default:
+		return nil, fmt.Errorf("unknown relay addr policy: %s", policy)
+	}
+}
+
+// NewDNSSuffixAddrFilter returns an AddrFilter that excludes DNS addrs with a name matching any of
+// the deny suffixes, and if any allow suffixes are given, DNS addrs with a name that matches none
+// of them. A name matches a suffix if it is equal to it or is a subdomain of it, e.g. the suffix
+// "example.com" matches both "example.com" and "node.example.com". Addrs that are not DNS addrs are
+// allowed.
+func NewDNSSuffixAddrFilter(allow, deny []string) AddrFilter {
+	allow = normalizeDNSSuffixes(allow)
+	deny = normalizeDNSSuffixes(deny)
+	return AddrFilterFunc(func(addr multiaddr.Multiaddr) bool {
+		c, _ := multiaddr.SplitFirst(addr)
+		if c == nil {
+			return true
+		}
+		switch c.Protocol().Code {
+		case multiaddr.P_DNS, multiaddr.P_DNS4, multiaddr.P_DNS6, multiaddr.P_DNSADDR:
+		default:
+			return true
+		}
+		name := strings.ToLower(strings.TrimSuffix(c.Value(), "."))
+		if matchesDNSSuffix(name, deny) {
+			return false
+		}
+		return len(allow) == 0 || matchesDNSSuffix(name, allow)
+	})
+}
+
+// filterAddrs filters the given addrs using the configured AddrFilter, if any.
+func (c *Caskadht) filterAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	if c.addrFilter == nil {
+		return addrs
+	}
+	return multiaddr.FilterAddrs(addrs, c.addrFilter.Allow)
+}
+
+// addrTransport returns the transport of the given addr, or of the relay addr if relayed and not
+// WebRTC. An empty string is returned if the transport is not recognised.
+func addrTransport(addr multiaddr.Multiaddr) string {
+	var transport string
+	var tls, relayed bool
+	multiaddr.ForEach(addr, func(c multiaddr.Component) bool {
+		if relayed {
+			// Only WebRTC is negotiated over the relayed connection; the remaining components
+			// identify the relayed peer.
+			if c.Protocol().Code == multiaddr.P_WEBRTC {
+				transport = TransportWebRTC
+				return false
+			}
+			return true
+		}
+		switch c.Protocol().Code {
+		case multiaddr.P_CIRCUIT:
+			relayed = true
+		case multiaddr.P_TCP:
+			transport = TransportTCP
+		case multiaddr.P_TLS:
+			tls = true
+		case multiaddr.P_WS:
+			// Websocket over TLS may also be expressed as /tls/ws instead of /wss.
+			if tls {
+				transport = TransportWSS
+			} else {
+				transport = TransportWS
+			}
+		case multiaddr.P_WSS:
+			transport = TransportWSS
+		case multiaddr.P_QUIC:
+			transport = TransportQUIC
+		case multiaddr.P_QUIC_V1:
+			transport = TransportQUICv1
+		case multiaddr.P_WEBTRANSPORT:
+			transport = TransportWebTransport
+		case multiaddr.P_WEBRTC_DIRECT:
+			transport = TransportWebRTCDirect
+		case multiaddr.P_WEBRTC:
+			transport = TransportWebRTC
+		}
+		return true
+	})
+	return transport
+}
+
+func isRelayAddr(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+func parseCIDRs(ss []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ss))
+	for _, s := range ss {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDNSSuffixes(suffixes []string) []string {
+	normalized := make([]string, 0, len(suffixes))
+	for _, s := range suffixes {
+		s = strings.ToLower(strings.Trim(s, "."))
+		if s != "" {
+			normalized = append(normalized, s)
+		}
+	}
+	return normalized
+}
+
+func matchesDNSSuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if name == s || strings.HasSuffix(name, "."+s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package caskadht
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AddrFilter(t *testing.T) {
+	cidrs, err := NewCIDRAddrFilter([]string{"147.75.0.0/16", "2604:1380::/32"}, []string{"147.75.83.0/24"})
+	require.NoError(t, err)
+	transports, err := NewTransportAddrFilter(TransportQUICv1, TransportWebTransport, TransportWSS)
+	require.NoError(t, err)
+	noRelay, err := NewRelayAddrFilter(RelayAddrsDeny)
+	require.NoError(t, err)
+	onlyRelay, err := NewRelayAddrFilter(RelayAddrsOnly)
+	require.NoError(t, err)
+	webRTC, err := NewTransportAddrFilter(TransportWebRTC)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		filter AddrFilter
+		given  []string
+		want   []string
+	}{
+		{
+			name:   "cidr",
+			filter: cidrs,
+			given: []string{
+				"/ip4/147.75.83.83/tcp/4001",
+				"/ip4/147.75.84.84/tcp/4001",
+				"/ip4/1.2.3.4/tcp/4001",
+				"/ip6/2604:1380:1000:6000::1/tcp/4001",
+				"/dns4/example.com/tcp/4001",
+			},
+			want: []string{
+				"/ip4/147.75.84.84/tcp/4001",
+				"/ip6/2604:1380:1000:6000::1/tcp/4001",
+				"/dns4/example.com/tcp/4001",
+			},
+		},
+		{
+			name:   "transport",
+			filter: transports,
+			given: []string{
+				"/ip4/1.2.3.4/tcp/4001",
+				"/ip4/1.2.3.4/udp/4001/quic",
+				"/ip4/1.2.3.4/udp/4001/quic-v1",
+				"/ip4/1.2.3.4/udp/4001/quic-v1/webtransport",
+				"/ip4/1.2.3.4/udp/4001/webrtc-direct",
+				"/dns4/example.com/tcp/443/tls/ws",
+				"/dns4/example.com/tcp/443/wss",
+				"/dns4/example.com/tcp/80/ws",
+				"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit",
+				"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit/webrtc",
+			},
+			want: []string{
+				"/ip4/1.2.3.4/udp/4001/quic-v1",
+				"/ip4/1.2.3.4/udp/4001/quic-v1/webtransport",
+				"/dns4/example.com/tcp/443/tls/ws",
+				"/dns4/example.com/tcp/443/wss",
+				"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit",
+			},
+		},
+		{
+			name:   "webrtc",
+			filter: webRTC,
+			given: []string{
+				"/ip4/1.2.3.4/udp/4001/webrtc-direct",
+				"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit",
+				"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit/webrtc",
+				"/ip4/1.2.3.4/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit/webrtc/p2p/12D3KooWHVXoJnv2ifmr9K6LWwJPXxkfvzZRHzjiTZMvybeTnwPy",
+			},
+			want: []string{
+				"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit/webrtc",
+				"/ip4/1.2.3.4/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit/webrtc/p2p/12D3KooWHVXoJnv2ifmr9K6LWwJPXxkfvzZRHzjiTZMvybeTnwPy",
+			},
+		},
+		{
+			name:   "no relay",
+			filter: noRelay,
+			given: []string{
+				"/ip4/1.2.3.4/tcp/4001",
+				"/ip4/1.2.3.4/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit",
+			},
+			want: []string{"/ip4/1.2.3.4/tcp/4001"},
+		},
+		{
+			name:   "only relay",
+			filter: onlyRelay,
+			given: []string{
+				"/ip4/1.2.3.4/tcp/4001",
+				"/ip4/1.2.3.4/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit",
+			},
+			want: []string{"/ip4/1.2.3.4/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit"},
+		},
+		{
+			name:   "dns suffix",
+			filter: NewDNSSuffixAddrFilter([]string{"example.com"}, []string{".internal.example.com"}),
+			given: []string{
+				"/dns4/example.com/tcp/4001",
+				"/dnsaddr/node.Example.com/tcp/4001",
+				"/dns6/node.internal.example.com/tcp/4001",
+				"/dns4/notexample.com/tcp/4001",
+				"/ip4/1.2.3.4/tcp/4001",
+			},
+			want: []string{
+				"/dns4/example.com/tcp/4001",
+				"/dnsaddr/node.Example.com/tcp/4001",
+				"/ip4/1.2.3.4/tcp/4001",
+			},
+		},
+		{
+			name:   "composed",
+			filter: AllAddrFilters(PubliclyDialableAddrFilter, AnyAddrFilter(transports, onlyRelay)),
+			given: []string{
+				"/ip4/127.0.0.1/udp/4001/quic-v1",
+				"/ip4/1.2.3.4/udp/4001/quic-v1",
+				"/ip4/1.2.3.4/tcp/4001",
+				"/ip4/1.2.3.4/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit",
+			},
+			want: []string{
+				"/ip4/1.2.3.4/udp/4001/quic-v1",
+				"/ip4/1.2.3.4/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			givenAddrs := make([]multiaddr.Multiaddr, 0, len(tt.given))
+			for _, a := range tt.given {
+				addr, err := multiaddr.NewMultiaddr(a)
+				require.NoError(t, err)
+				givenAddrs = append(givenAddrs, addr)
+			}
+			gotAddrs := multiaddr.FilterAddrs(givenAddrs, tt.filter.Allow)
+			got := make([]string, 0, len(gotAddrs))
+			for _, addr := range gotAddrs {
+				got = append(got, addr.String())
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err = NewTransportAddrFilter("carrier-pigeon")
+	require.Error(t, err)
+	_, err = NewRelayAddrFilter("sometimes")
+	require.Error(t, err)
+	_, err = NewCIDRAddrFilter([]string{"fish"}, nil)
+	require.Error(t, err)
+}
+
+func Test_WithAddrFilterDisabledKeepsAddrFilter(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+	quic, err := NewTransportAddrFilter(TransportQUICv1)
+	require.NoError(t, err)
+
+	opts, err := newOptions(WithHost(h), WithAddrFilter(quic), WithAddrFilterDisabled(false))
+	require.NoError(t, err)
+	require.False(t, opts.addrFilter.Allow(multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")))
+
+	opts, err = newOptions(WithHost(h), WithAddrFilterDisabled(false), WithAddrFilter(quic))
+	require.NoError(t, err)
+	require.False(t, opts.addrFilter.Allow(multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")))
+
+	// Disabling must take precedence regardless of the order of options.
+	opts, err = newOptions(WithHost(h), WithAddrFilter(quic), WithAddrFilterDisabled(true))
+	require.NoError(t, err)
+	require.Nil(t, opts.addrFilter)
+	opts, err = newOptions(WithHost(h), WithAddrFilterDisabled(true), WithAddrFilter(quic))
+	require.NoError(t, err)
+	require.Nil(t, opts.addrFilter)
+	opts, err = newOptions(WithHost(h), WithAddrFilterDisabled(true), WithAddrFilterDisabled(false))
+	require.NoError(t, err)
+	require.NotNil(t, opts.addrFilter)
+	require.False(t, opts.addrFilter.Allow(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")))
+}
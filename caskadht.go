@@ -3,27 +3,24 @@ package caskadht
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-ipns"
 	"github.com/ipfs/go-log/v2"
 	"github.com/ipni/go-libipni/apierror"
 	"github.com/ipni/go-libipni/find/model"
 	"github.com/ipni/go-libipni/rwriter"
-	dht "github.com/libp2p/go-libp2p-kad-dht"
-	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
-	record "github.com/libp2p/go-libp2p-record"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
-	"github.com/libp2p/go-libp2p/core/routing"
-	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
+	"golang.org/x/sync/singleflight"
 )
 
 const ipniCascadeQueryKey = "cascade"
@@ -37,15 +34,29 @@ var (
 
 type Caskadht struct {
 	*options
-	std     *dht.IpfsDHT
-	acc     *fullrt.FullRT
-	s       *http.Server
+	// backends are the DHTs onto which lookups are cascaded, the first of which is the default.
+	backends []*dhtBackend
+	s        *http.Server
+	// gcs serves group cache requests of other replicas, if group cache is enabled.
+	gcs *http.Server
+	// as serves the admin endpoints, if the peer filter is enabled.
+	as      *http.Server
 	metrics *metrics
 
 	// Context and cancellation used to terminate streaming responses on shutdown.
-	ctx      context.Context
-	cancel   context.CancelFunc
-	attCache *peerRoutingAttemptCache
+	ctx        context.Context
+	cancel     context.CancelFunc
+	attCache   *peerRoutingAttemptCache
+	groupCache *groupCache
+	static     *staticProviders
+	denylist   *denylist
+	peerFilter *peerFilter
+	// ipniResults caches the results of IPNI upstreams, if the result cache is enabled.
+	ipniResults *ipniResultCache
+	// ipniLookups coalesces concurrent IPNI upstream requests for the same multihash.
+	ipniLookups singleflight.Group
+	// revalidations bounds the number of concurrent background refreshes of stale cached results.
+	revalidations chan struct{}
 }
 
 const ipfsProtocolPrefix = "/ipfs"
@@ -57,6 +68,9 @@ func New(o ...Option) (*Caskadht, error) {
 	}
 	var c Caskadht
 	c.options = opts
+	if opts.groupCacheSelf != "" && opts.resultCacheMaxAge <= 0 {
+		return nil, errors.New("group cache requires a positive result cache max age")
+	}
 	c.s = &http.Server{
 		Addr:    opts.httpListenAddr,
 		Handler: c.serveMux(),
@@ -64,10 +78,71 @@ func New(o ...Option) (*Caskadht, error) {
 	c.ctx, c.cancel = context.WithCancel(context.Background())
 	c.s.RegisterOnShutdown(c.cancel)
 	c.attCache = newPeerRoutingAttemptCache(opts.prAttemptCacheMaxSize, opts.prAttemptCacheMaxAge)
+	primary, err := c.newDHTBackend(dhtBackendConfig{
+		label:          opts.ipniCascadeLabel,
+		protocolPrefix: opts.dhtProtocolPrefix,
+		bootstrapPeers: opts.bootstrapPeers,
+		useAccDHT:      opts.useAccDHT,
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	c.backends = append(c.backends, primary)
+	for _, cfg := range opts.dhtBackends {
+		b, err := c.newDHTBackend(cfg, false)
+		if err != nil {
+			return nil, err
+		}
+		c.backends = append(c.backends, b)
+	}
+	if opts.staticProvidersPath != "" {
+		c.static = newStaticProviders(opts.staticProvidersPath)
+		if err := c.static.load(); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.denylistPaths) != 0 {
+		c.denylist = newDenylist(opts.denylistPaths)
+		if err := c.denylist.load(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.peerFilterPath != "" || opts.peerFilterAllowlist {
+		c.peerFilter = newPeerFilter(opts.peerFilterPath, opts.peerFilterAllowlist)
+		if err := c.peerFilter.load(); err != nil {
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc(peerFilterAdminPath, c.handlePeerFilterAdmin)
+		mux.HandleFunc(peerFilterAdminPath+"/", c.handlePeerFilterAdmin)
+		c.as = &http.Server{
+			Addr:    opts.adminHttpListenAddr,
+			Handler: mux,
+		}
+	}
+	if opts.resultCacheMaxSize > 0 {
+		c.revalidations = make(chan struct{}, opts.resultCacheMaxRevalidations)
+		if len(opts.ipniUpstreams) != 0 {
+			c.ipniResults = newIpniResultCache(opts.resultCacheMaxSize, opts.resultCacheMaxAge, opts.resultCacheNegativeMaxAge)
+		}
+	}
 	c.metrics, err = newMetrics(&c)
 	if err != nil {
 		return nil, err
 	}
+	// Open the group cache last, since it must be closed once no longer used.
+	if opts.groupCacheSelf != "" {
+		c.groupCache, err = newGroupCache(opts.groupCacheSelf, opts.groupCachePeers, opts.groupCacheMaxBytes, opts.resultCacheMaxAge, opts.resultCacheNegativeMaxAge, c.lookupToCompletion)
+		if err != nil {
+			return nil, err
+		}
+		mux := http.NewServeMux()
+		mux.Handle(groupCacheBasePath, c.groupCache)
+		c.gcs = &http.Server{
+			Addr:    opts.groupCacheListenAddr,
+			Handler: mux,
+		}
+	}
 	return &c, nil
 }
 
@@ -75,28 +150,29 @@ func (c *Caskadht) Start(ctx context.Context) error {
 	if err := c.metrics.Start(ctx); err != nil {
 		return err
 	}
-	var err error
-	// TODO parameterize options
-	c.std, err = dht.New(ctx, c.h, dht.Mode(dht.ModeClient), dht.BootstrapPeers(c.bootstrapPeers...))
-	if err != nil {
-		return err
-	}
-
-	if c.useAccDHT {
-		// TODO: parameterize options
-		c.acc, err = fullrt.NewFullRT(c.h, ipfsProtocolPrefix,
-			fullrt.DHTOption(
-				dht.BucketSize(20),
-				dht.Validator(record.NamespacedValidator{
-					"pk":   record.PublicKeyValidator{},
-					"ipns": ipns.Validator{},
-				}),
-				dht.BootstrapPeers(c.bootstrapPeers...),
-				dht.Mode(dht.ModeClient),
-			))
-		if err != nil {
+	for _, b := range c.backends {
+		if err := c.loadResultStore(ctx, b); err != nil {
+			return err
+		}
+		if err := c.startDHTBackend(ctx, b); err != nil {
 			return err
 		}
+		if b.provides != nil {
+			if err := b.provides.load(ctx); err != nil {
+				return err
+			}
+			go c.expireProvides(b)
+		}
+	}
+
+	if c.static != nil {
+		go c.watchStaticProviders()
+	}
+	if c.denylist != nil {
+		go c.watchDenylist()
+	}
+	if c.peerFilter != nil && c.peerFilter.path != "" {
+		go c.watchPeerFilter()
 	}
 
 	ln, err := net.Listen("tcp", c.s.Addr)
@@ -104,17 +180,61 @@ func (c *Caskadht) Start(ctx context.Context) error {
 		return err
 	}
 	go func() { _ = c.s.Serve(ln) }()
+	if c.gcs != nil {
+		gln, err := net.Listen("tcp", c.gcs.Addr)
+		if err != nil {
+			return err
+		}
+		go func() { _ = c.gcs.Serve(gln) }()
+		logger.Infow("Group cache server started", "addr", gln.Addr())
+	}
+	if c.as != nil {
+		aln, err := net.Listen("tcp", c.as.Addr)
+		if err != nil {
+			return err
+		}
+		go func() { _ = c.as.Serve(aln) }()
+		logger.Infow("Admin server started", "addr", aln.Addr())
+	}
 	logger.Infow("Server started", "id", c.h.ID(), "libp2pAddrs", c.h.Addrs(), "httpAddr", ln.Addr())
 	return nil
 }
 
+// loadResultStore populates the provider result cache of the given backend and the peerstore from
+// the unexpired entries of its result store, if any.
+func (c *Caskadht) loadResultStore(ctx context.Context, b *dhtBackend) error {
+	if b.resultStore == nil {
+		return nil
+	}
+	var providerCount, peerCount int
+	if b.resultCache != nil {
+		if err := b.resultStore.forEachProviders(ctx, func(key multihash.Multihash, result *storedResult) {
+			b.resultCache.putAt(key, result.Providers, result.At)
+			providerCount++
+		}); err != nil {
+			return err
+		}
+	}
+	if err := b.resultStore.forEachPeer(ctx, func(p *storedPeer) {
+		c.h.Peerstore().AddAddrs(p.AddrInfo.ID, p.AddrInfo.Addrs, time.Until(p.Expiry))
+		peerCount++
+	}); err != nil {
+		return err
+	}
+	logger.Infow("Loaded result store", "cascadeLabel", b.label, "providerResults", providerCount, "peers", peerCount)
+	return nil
+}
+
 func (c *Caskadht) serveMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/cid", c.handleMh)
 	mux.HandleFunc("/cid/", c.handleMhSubtree)
 	mux.HandleFunc("/multihash", c.handleMh)
 	mux.HandleFunc("/multihash/", c.handleMhSubtree)
+	mux.HandleFunc("/routing/v1/providers", c.handleRoutingV1ProvidersSubtree)
 	mux.HandleFunc("/routing/v1/providers/", c.handleRoutingV1ProvidersSubtree)
+	mux.HandleFunc(drPeersPathPrefix, c.handleRoutingV1PeersSubtree)
+	mux.HandleFunc(drIpnsPathPrefix, c.handleRoutingV1IpnsSubtree)
 	mux.HandleFunc("/ready", c.handleReady)
 	mux.HandleFunc("/", c.handleCatchAll)
 	return mux
@@ -123,7 +243,7 @@ func (c *Caskadht) serveMux() *http.ServeMux {
 func (c *Caskadht) handleMh(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodOptions:
-		c.handleLookupOptions(w)
+		c.handleLookupOptions(w, "GET, OPTIONS")
 	default:
 		w.Header().Set("Allow", http.MethodGet)
 		w.Header().Add("Allow", http.MethodOptions)
@@ -145,8 +265,12 @@ func (c *Caskadht) handleMhSubtree(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "", http.StatusBadRequest)
 			return
 		}
+		if c.blocked(r.Context(), rspWriter.Cid(), denylistAPIIpni) {
+			http.Error(w, "", http.StatusGone)
+			return
+		}
+		b, present, matched := c.backendForRequest(r)
 		if c.ipniRequireCascadeQueryParam {
-			present, matched := rwriter.MatchQueryParam(r, ipniCascadeQueryKey, c.ipniCascadeLabel)
 			if !present {
 				logger.Debugw("Rejected request with unspecified cascade query parameter.")
 				http.Error(w, "", http.StatusNotFound)
@@ -154,14 +278,14 @@ func (c *Caskadht) handleMhSubtree(w http.ResponseWriter, r *http.Request) {
 			}
 			if !matched {
 				labels := r.URL.Query()[ipniCascadeQueryKey]
-				logger.Infow("Rejected request with mismatching cascade label.", "want", c.ipniCascadeLabel, "got", labels)
+				logger.Infow("Rejected request with mismatching cascade label.", "want", c.cascadeLabels(), "got", labels)
 				http.Error(w, "", http.StatusNotFound)
 				return
 			}
 		}
-		c.handleLookup(rwriter.NewProviderResponseWriter(rspWriter), r)
+		c.handleLookup(rwriter.NewProviderResponseWriter(rspWriter), r, b)
 	case http.MethodOptions:
-		c.handleLookupOptions(w)
+		c.handleLookupOptions(w, "GET, OPTIONS")
 	default:
 		w.Header().Set("Allow", http.MethodGet)
 		w.Header().Add("Allow", http.MethodOptions)
@@ -172,7 +296,7 @@ func (c *Caskadht) handleMhSubtree(w http.ResponseWriter, r *http.Request) {
 func (c *Caskadht) handleRoutingV1ProvidersSubtree(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		drWriter, err := newDelegatedRoutingLookupResponseWriter(w, r, c.httpResponsePreferJson)
+		drWriter, err := newDelegatedRoutingLookupResponseWriter(w, r, c.httpResponsePreferJson, c.drSchema)
 		if err != nil {
 			var apiErr *apierror.Error
 			if errors.As(err, &apiErr) {
@@ -183,34 +307,76 @@ func (c *Caskadht) handleRoutingV1ProvidersSubtree(w http.ResponseWriter, r *htt
 			http.Error(w, "", http.StatusBadRequest)
 			return
 		}
-		c.handleDrLookup(drWriter, r)
+		if c.blocked(r.Context(), drWriter.Cid(), denylistAPIDelegatedRouting) {
+			http.Error(w, "", http.StatusGone)
+			return
+		}
+		b, _, _ := c.backendForRequest(r)
+		c.handleDrLookup(drWriter, r, b)
 	case http.MethodOptions:
-		c.handleLookupOptions(w)
+		c.handleLookupOptions(w, "GET, PUT, OPTIONS")
 	case http.MethodPut:
-		http.Error(w, "", http.StatusNotImplemented)
+		c.handlePutProviders(w, r)
 	default:
 		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPut)
 		w.Header().Add("Allow", http.MethodOptions)
 		http.Error(w, "", http.StatusMethodNotAllowed)
 	}
 }
 
-func (c *Caskadht) handleLookup(w *rwriter.ProviderResponseWriter, r *http.Request) {
+func (c *Caskadht) handleLookup(w *rwriter.ProviderResponseWriter, r *http.Request, b *dhtBackend) {
 	ctx, cancel := context.WithCancel(r.Context())
-	pch := c.cascadeFindProviders(ctx, w.Cid())
+	pch := c.cascadeFindProviders(ctx, b, w.Cid())
+	ich := c.ipniUpstreamFindProviders(ctx, w.Cid())
 	defer cancel()
+	c.setCacheControl(w, b, w.Cid().Hash())
+	filter := newQueryFilter(r.URL.Query())
+	writeResult := func(result model.ProviderResult) error {
+		result, ok := filter.filterProviderResult(result)
+		if !ok {
+			return nil
+		}
+		return w.WriteProviderResult(result)
+	}
+	// Serve static and HTTP provided providers first, and skip them if found by lookups.
+	local := c.localProviders(ctx, b, w.Cid())
+	for _, provider := range local {
+		provider := provider
+		if err := writeResult(model.ProviderResult{
+			ContextID: cascadeContextID,
+			Metadata:  cascadeMetadata,
+			Provider:  &provider,
+		}); err != nil {
+			logger.Errorw("Failed to encode local provider record", "err", err)
+		}
+	}
 LOOP:
-	for {
+	for pch != nil || ich != nil {
 		select {
 		case <-c.ctx.Done():
 			logger.Debugw("Interrupted while responding to lookup", "key", w.Cid(), "err", ctx.Err())
 			break LOOP
+		case result, ok := <-ich:
+			if !ok {
+				ich = nil
+				continue
+			}
+			// Preserve the original context ID and metadata of results from IPNI upstreams.
+			if err := writeResult(result); err != nil {
+				logger.Errorw("Failed to encode provider record", "err", err)
+				break LOOP
+			}
 		case provider, ok := <-pch:
 			if !ok {
 				logger.Debugw("No more provider records", "key", w.Cid())
-				break LOOP
+				pch = nil
+				continue
+			}
+			if containsProvider(local, provider.ID) {
+				continue
 			}
-			err := w.WriteProviderResult(model.ProviderResult{
+			err := writeResult(model.ProviderResult{
 				ContextID: cascadeContextID,
 				Metadata:  cascadeMetadata,
 				Provider: &peer.AddrInfo{
@@ -235,20 +401,39 @@ LOOP:
 	}
 }
 
-func (c *Caskadht) handleDrLookup(w *delegatedRoutingLookupResponseWriter, r *http.Request) {
+func (c *Caskadht) handleDrLookup(w *delegatedRoutingLookupResponseWriter, r *http.Request, b *dhtBackend) {
 	ctx, cancel := context.WithCancel(r.Context())
-	pch := c.cascadeFindProviders(ctx, w.Cid())
+	pch := c.cascadeFindProviders(ctx, b, w.Cid())
+	ich := c.ipniUpstreamFindProviders(ctx, w.Cid())
 	defer cancel()
+	c.setCacheControl(w, b, w.Cid().Hash())
+	// Serve static and HTTP provided providers first; duplicates found by lookups are skipped by
+	// the writer.
+	for _, provider := range c.localProviders(ctx, b, w.Cid()) {
+		if err := w.writeDrProviderRecord(provider); err != nil {
+			logger.Errorw("Failed to encode local provider record", "err", err)
+		}
+	}
 LOOP:
-	for {
+	for pch != nil || ich != nil {
 		select {
 		case <-c.ctx.Done():
 			logger.Debugw("Interrupted while responding to lookup", "key", w.Cid(), "err", ctx.Err())
 			break LOOP
+		case result, ok := <-ich:
+			if !ok {
+				ich = nil
+				continue
+			}
+			if err := w.writeIpniProviderResult(result); err != nil {
+				logger.Errorw("Failed to encode provider record", "err", err)
+				break LOOP
+			}
 		case provider, ok := <-pch:
 			if !ok {
 				logger.Debugw("No more provider records", "key", w.Cid())
-				break LOOP
+				pch = nil
+				continue
 			}
 			err := w.writeDrProviderRecord(provider)
 			if err != nil {
@@ -263,22 +448,214 @@ LOOP:
 	}
 }
 
-func (c *Caskadht) cascadeFindProviders(ctx context.Context, key cid.Cid) <-chan peer.AddrInfo {
+// backendForRequest returns the DHT backend whose label matches the IPNI cascade query parameter
+// of the given request, or the default backend if there is no match. Whether the query parameter
+// is present and whether it matched any backend are also returned.
+func (c *Caskadht) backendForRequest(r *http.Request) (*dhtBackend, bool, bool) {
+	labels, present := r.URL.Query()[ipniCascadeQueryKey]
+	for _, label := range labels {
+		if b := c.backend(label); b != nil {
+			return b, true, true
+		}
+	}
+	return c.backends[0], present, false
+}
+
+// cascadeLabels returns the IPNI cascade labels of all DHT backends.
+func (c *Caskadht) cascadeLabels() []string {
+	labels := make([]string, 0, len(c.backends))
+	for _, b := range c.backends {
+		labels = append(labels, b.label)
+	}
+	return labels
+}
+
+func (c *Caskadht) cascadeFindProviders(ctx context.Context, b *dhtBackend, key cid.Cid) <-chan peer.AddrInfo {
 	start := time.Now()
 	c.metrics.notifyLookupRequested(ctx)
-	var timeToFirstProvider time.Duration
+	if b.resultCache != nil {
+		if providers, found, revalidate := c.getCachedProviders(ctx, b, key.Hash()); found {
+			if revalidate {
+				c.revalidate(b, key)
+			}
+			if len(providers) == 0 {
+				c.metrics.notifyLookupCacheNegativeHit(ctx)
+			} else {
+				c.metrics.notifyLookupCacheHit(ctx)
+			}
+			return c.streamCachedProviders(ctx, providers, start)
+		}
+		c.metrics.notifyLookupCacheMiss(ctx)
+	}
+	// Fetch providers from the replica that owns the key, if it is not owned by this replica.
+	// Note that unlike local lookups, the results are only available once the owner's lookup
+	// completes.
+	if c.groupCache != nil && !c.groupCache.isLocal(key.Hash()) {
+		providers, err := c.groupCache.get(ctx, b.label, key.Hash())
+		switch {
+		case err == nil:
+			return c.streamCachedProviders(ctx, providers, start)
+		case errors.Is(err, errGroupCacheNegativeExpired):
+			logger.Debugw("Group cache result expired; falling back on local lookup", "key", key)
+		default:
+			logger.Warnw("Failed to get providers from group cache; falling back on local lookup", "key", key, "err", err)
+		}
+	}
+	// Join any in-flight lookup for the same multihash; the shared lookup outlives individual
+	// requests and is cancelled either on shutdown or when its last subscriber leaves.
+	sl, leave := b.lookups.join(c.ctx, string(key.Hash()), func(ctx context.Context) <-chan peer.AddrInfo {
+		return c.findProviders(ctx, b, key)
+	})
+	rch := make(chan peer.AddrInfo, 1)
+	go func() {
+		var resultCount int64
+		var timeToFirstProvider time.Duration
+		defer func() {
+			close(rch)
+			leave()
+			c.metrics.notifyLookupResponded(context.Background(), resultCount, timeToFirstProvider, time.Since(start))
+		}()
+		for i := 0; ; i++ {
+			provider, ok := sl.next(ctx, i)
+			if !ok {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case rch <- provider:
+				resultCount++
+				if resultCount == 1 {
+					timeToFirstProvider = time.Since(start)
+				}
+			}
+		}
+	}()
+	return rch
+}
+
+// getCachedProviders gets the cached providers of the given key from the result cache of the given
+// backend, falling back on its result store if any. Results found in the store are added to the
+// cache.
+func (c *Caskadht) getCachedProviders(ctx context.Context, b *dhtBackend, key multihash.Multihash) ([]peer.AddrInfo, bool, bool) {
+	providers, found, revalidate := b.resultCache.get(key)
+	if found || b.resultStore == nil {
+		return providers, found, revalidate
+	}
+	result, found, err := b.resultStore.getProviders(ctx, key)
+	if err != nil {
+		logger.Errorw("Failed to get providers from result store", "key", key, "err", err)
+		return nil, false, false
+	}
+	if !found {
+		return nil, false, false
+	}
+	b.resultCache.putAt(key, result.Providers, result.At)
+	return b.resultCache.get(key)
+}
+
+// cacheProviders caches the providers found for the given key by the given backend, and persists
+// them in its result store if any.
+func (c *Caskadht) cacheProviders(ctx context.Context, b *dhtBackend, key multihash.Multihash, providers []peer.AddrInfo) {
+	if b.resultCache == nil || !b.resultCache.cacheable(providers) {
+		return
+	}
+	at := time.Now()
+	b.resultCache.putAt(key, providers, at)
+	if b.resultStore != nil {
+		if err := b.resultStore.putProviders(ctx, key, &storedResult{
+			Providers: providers,
+			At:        at,
+			Expiry:    b.resultCache.expiry(providers, at),
+		}); err != nil {
+			logger.Errorw("Failed to persist providers in result store", "key", key, "err", err)
+		}
+	}
+}
+
+// lookupToCompletion looks up the providers of the given multihash on the backend with the given
+// label, blocking until the lookup completes. An error is returned if the lookup is interrupted.
+func (c *Caskadht) lookupToCompletion(ctx context.Context, label string, mh multihash.Multihash) ([]peer.AddrInfo, error) {
+	b := c.backend(label)
+	if b == nil {
+		return nil, fmt.Errorf("unknown cascade label: %s", label)
+	}
+	key := cid.NewCidV1(cid.Raw, mh)
+	sl, leave := b.lookups.join(c.ctx, string(mh), func(ctx context.Context) <-chan peer.AddrInfo {
+		return c.findProviders(ctx, b, key)
+	})
+	defer leave()
+	var providers []peer.AddrInfo
+	for i := 0; ; i++ {
+		provider, ok := sl.next(ctx, i)
+		if !ok {
+			break
+		}
+		providers = append(providers, provider)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
+// revalidate refreshes the cached result of the given backend for the given key in the
+// background, unless the maximum number of concurrent revalidations is reached.
+func (c *Caskadht) revalidate(b *dhtBackend, key cid.Cid) {
+	select {
+	case c.revalidations <- struct{}{}:
+	default:
+		logger.Debugw("Too many concurrent revalidations; serving stale result without refresh", "key", key)
+		b.resultCache.endRevalidation(key.Hash())
+		return
+	}
+	// Join the shared lookup so that the revalidation is coalesced with requests that arrive
+	// after the stale result expires. The lookup caches its result upon completion.
+	sl, leave := b.lookups.join(c.ctx, string(key.Hash()), func(ctx context.Context) <-chan peer.AddrInfo {
+		return c.findProviders(ctx, b, key)
+	})
+	go func() {
+		defer func() {
+			leave()
+			<-c.revalidations
+			// Allow another revalidation if the lookup did not replace the stale result, e.g.
+			// because it was interrupted.
+			b.resultCache.endRevalidation(key.Hash())
+		}()
+		for i := 0; ; i++ {
+			if _, ok := sl.next(c.ctx, i); !ok {
+				return
+			}
+		}
+	}()
+}
+
+// findProviders finds the providers of the given key from the provider sources of the given
+// backend, e.g. by walking its DHT, populating and filtering their addrs as needed. The result of
+// lookups that run to completion are cached.
+func (c *Caskadht) findProviders(ctx context.Context, b *dhtBackend, key cid.Cid) <-chan peer.AddrInfo {
 	rch := make(chan peer.AddrInfo, 1)
 	go func() {
-		var resultCount atomic.Int64
 		var fpwg sync.WaitGroup
+		var found []peer.AddrInfo
 		fpch := make(chan peer.AddrInfo, 1)
 		defer func() {
 			close(rch)
-			c.metrics.notifyLookupResponded(context.Background(), resultCount.Load(), timeToFirstProvider, time.Since(start))
 			fpwg.Wait()
 			close(fpch)
 		}()
-		dhtch := c.routing().FindProvidersAsync(ctx, key, c.findProvidersLimit)
+		sources := c.providerSources(b)
+		chs := make([]<-chan peer.AddrInfo, 0, len(sources))
+		for _, source := range sources {
+			chs = append(chs, c.sourceFindProviders(ctx, source, key))
+		}
+		srcch := chs[0]
+		if len(chs) > 1 {
+			srcch = mergeProviders(ctx, c.findProvidersLimit, chs...)
+		}
 		for {
 			select {
 			case <-ctx.Done():
@@ -288,30 +665,34 @@ func (c *Caskadht) cascadeFindProviders(ctx context.Context, key cid.Cid) <-chan
 					return
 				}
 				// If addrs should be filtered; do so.
-				if !c.addrFilterDisabled {
-					provider.Addrs = multiaddr.FilterAddrs(provider.Addrs, IsPubliclyDialableAddr)
-				}
+				provider.Addrs = c.filterAddrs(provider.Addrs)
 				// If after filtering no addrs are left, skip the result.
 				if len(provider.Addrs) == 0 {
-					logger.Debugw("Found no public addrs for peer ID; skipping provider", "id", provider.ID)
+					logger.Debugw("Found no addrs that pass the addr filter for peer ID; skipping provider", "id", provider.ID)
 					continue
 				}
 				select {
 				case <-ctx.Done():
 					return
 				case rch <- provider:
-					if resultCount.Add(1) == 1 {
-						timeToFirstProvider = time.Since(start)
-					}
+					found = append(found, provider)
 				}
-			case provider, ok := <-dhtch:
+			case provider, ok := <-srcch:
 				if !ok {
+					// Only cache the result of lookups that ran to completion, including the ones
+					// that found no providers. Note, sources close the channel on cancellation too.
+					if ctx.Err() == nil {
+						c.cacheProviders(ctx, b, key.Hash(), found)
+					}
 					return
 				}
 				if err := provider.ID.Validate(); err != nil {
 					logger.Debugw("Skipping provider record with invalid ID", "err", err)
 					continue
 				}
+				if !c.allowedProvider(ctx, provider.ID) {
+					continue
+				}
 				// If there are no addrs, populate addrs from local peerstore.
 				if len(provider.Addrs) == 0 {
 					provider.Addrs = c.h.Peerstore().Addrs(provider.ID)
@@ -323,7 +704,7 @@ func (c *Caskadht) cascadeFindProviders(ctx context.Context, key cid.Cid) <-chan
 					fpwg.Add(1)
 					go func(pid peer.ID) {
 						defer fpwg.Done()
-						found, err := c.routing().FindPeer(ctx, pid)
+						found, err := b.routing().FindPeer(ctx, pid)
 						if err != nil {
 							logger.Errorw("Failed to discover addrs for peer ID; skipping provider.", "id", provider.ID, "err", err)
 							return
@@ -333,6 +714,14 @@ func (c *Caskadht) cascadeFindProviders(ctx context.Context, key cid.Cid) <-chan
 							return
 						}
 						c.h.Peerstore().AddAddrs(found.ID, found.Addrs, peerstore.AddressTTL)
+						if b.resultStore != nil {
+							if err := b.resultStore.putPeer(ctx, &storedPeer{
+								AddrInfo: found,
+								Expiry:   time.Now().Add(peerstore.AddressTTL),
+							}); err != nil {
+								logger.Errorw("Failed to persist peer addrs in result store", "id", found.ID, "err", err)
+							}
+						}
 						select {
 						case <-ctx.Done():
 							return
@@ -343,13 +732,11 @@ func (c *Caskadht) cascadeFindProviders(ctx context.Context, key cid.Cid) <-chan
 				}
 
 				// If addrs should be filtered; do so.
-				if !c.addrFilterDisabled {
-					provider.Addrs = multiaddr.FilterAddrs(provider.Addrs, IsPubliclyDialableAddr)
-				}
+				provider.Addrs = c.filterAddrs(provider.Addrs)
 
 				// If after filtering no addrs are left, skip the result.
 				if len(provider.Addrs) == 0 {
-					logger.Debugw("Found no public addrs for peer ID; skipping provider", "id", provider.ID)
+					logger.Debugw("Found no addrs that pass the addr filter for peer ID; skipping provider", "id", provider.ID)
 					continue
 				}
 
@@ -357,9 +744,7 @@ func (c *Caskadht) cascadeFindProviders(ctx context.Context, key cid.Cid) <-chan
 				case <-ctx.Done():
 					return
 				case rch <- provider:
-					if resultCount.Add(1) == 1 {
-						timeToFirstProvider = time.Since(start)
-					}
+					found = append(found, provider)
 				}
 			}
 		}
@@ -368,18 +753,104 @@ func (c *Caskadht) cascadeFindProviders(ctx context.Context, key cid.Cid) <-chan
 	return rch
 }
 
-func (c *Caskadht) routing() routing.Routing {
-	if c.useAccDHT && c.acc.Ready() {
-		return c.acc
+func (c *Caskadht) streamCachedProviders(ctx context.Context, providers []peer.AddrInfo, start time.Time) <-chan peer.AddrInfo {
+	rch := make(chan peer.AddrInfo, 1)
+	go func() {
+		var resultCount int64
+		var timeToFirstProvider time.Duration
+		defer func() {
+			close(rch)
+			c.metrics.notifyLookupResponded(context.Background(), resultCount, timeToFirstProvider, time.Since(start))
+		}()
+		for _, provider := range providers {
+			// Re-apply the peer filter, since it may have changed since the providers were cached.
+			if !c.allowedProvider(ctx, provider.ID) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case rch <- provider:
+				resultCount++
+				if resultCount == 1 {
+					timeToFirstProvider = time.Since(start)
+				}
+			}
+		}
+	}()
+	return rch
+}
+
+// staticProviders returns the static providers of the given key, if any.
+func (c *Caskadht) staticProviders(ctx context.Context, key cid.Cid) []peer.AddrInfo {
+	if c.static == nil {
+		return nil
+	}
+	providers := c.static.get(key)
+	if len(providers) != 0 {
+		c.metrics.notifyStaticProvidersHit(ctx)
+	}
+	return providers
+}
+
+// localProviders returns the static providers of the given key followed by the ones provided via
+// the delegated routing HTTP API, if any.
+func (c *Caskadht) localProviders(ctx context.Context, b *dhtBackend, key cid.Cid) []peer.AddrInfo {
+	static := c.staticProviders(ctx, key)
+	provided := c.httpProvidedProviders(ctx, b, key)
+	if len(provided) == 0 {
+		return static
+	}
+	local := make([]peer.AddrInfo, 0, len(static)+len(provided))
+	local = append(local, static...)
+	for _, provider := range provided {
+		if !containsProvider(local, provider.ID) {
+			local = append(local, provider)
+		}
+	}
+	return local
+}
+
+func containsProvider(providers []peer.AddrInfo, id peer.ID) bool {
+	for _, p := range providers {
+		if p.ID == id {
+			return true
+		}
 	}
-	return c.std
+	return false
 }
 
-func (c *Caskadht) handleLookupOptions(w http.ResponseWriter) {
+// setCacheControl sets the Cache-Control header of lookup responses for the given key according
+// to its cached result on the given backend, if any. Responses served from cache are only fresh
+// for the remainder of the cached result's max age, or negative max age if it found no providers.
+// No header is set on cache misses, since the outcome of the lookup is not yet known.
+func (c *Caskadht) setCacheControl(w http.ResponseWriter, b *dhtBackend, key multihash.Multihash) {
+	if b.resultCache == nil {
+		return
+	}
+	maxAge, found := b.resultCache.ttl(key)
+	if !found {
+		return
+	}
+	staleWhileReval := c.resultCacheStaleWhileReval
+	if maxAge < 0 {
+		staleWhileReval += maxAge
+		maxAge = 0
+	}
+	cc := fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	if staleWhileReval > 0 {
+		cc += fmt.Sprintf(", stale-while-revalidate=%d", int(staleWhileReval.Seconds()))
+	}
+	w.Header().Set("Cache-Control", cc)
+}
+
+// handleLookupOptions responds to CORS preflight requests of lookup endpoints, which allow the
+// given comma separated methods.
+func (c *Caskadht) handleLookupOptions(w http.ResponseWriter, allowMethods string) {
 	w.Header().Set("Access-Control-Allow-Origin", c.httpAllowOrigin)
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("X-IPNI-Allow-Cascade", c.ipniCascadeLabel)
+	w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+	w.Header().Set("X-IPNI-Allow-Cascade", strings.Join(c.cascadeLabels(), ","))
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -400,9 +871,19 @@ func (c *Caskadht) handleCatchAll(w http.ResponseWriter, r *http.Request) {
 
 func (c *Caskadht) Shutdown(ctx context.Context) error {
 	sErr := c.s.Shutdown(ctx)
-	_ = c.std.Close()
-	if c.acc != nil {
-		_ = c.acc.Close()
+	if c.gcs != nil {
+		if err := c.gcs.Shutdown(ctx); err != nil && sErr == nil {
+			sErr = err
+		}
+		c.groupCache.close()
+	}
+	if c.as != nil {
+		if err := c.as.Shutdown(ctx); err != nil && sErr == nil {
+			sErr = err
+		}
+	}
+	for _, b := range c.backends {
+		b.close()
 	}
 	hErr := c.h.Close()
 	_ = c.metrics.Shutdown(ctx)
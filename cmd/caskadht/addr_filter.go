@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/ipni/caskadht"
+)
+
+// addrFilterConfig is the JSON representation of the addr filter policies, all of which must
+// allow an addr for it to be included in lookup results, e.g.:
+//
+//	{
+//	  "publiclyDialable": true,
+//	  "denyCIDRs": ["100.64.0.0/10"],
+//	  "transports": ["tcp", "quic-v1", "webtransport", "webrtc-direct"],
+//	  "relay": "deny",
+//	  "denyDNSSuffixes": ["internal.example.com"]
+//	}
+//
+// Policies that are unspecified allow all addrs, except publiclyDialable which defaults to true.
+type addrFilterConfig struct {
+	PubliclyDialable *bool                    `json:"publiclyDialable"`
+	AllowCIDRs       []string                 `json:"allowCIDRs"`
+	DenyCIDRs        []string                 `json:"denyCIDRs"`
+	Transports       []string                 `json:"transports"`
+	Relay            caskadht.RelayAddrPolicy `json:"relay"`
+	AllowDNSSuffixes []string                 `json:"allowDNSSuffixes"`
+	DenyDNSSuffixes  []string                 `json:"denyDNSSuffixes"`
+}
+
+func loadAddrFilter(path string) (caskadht.AddrFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg addrFilterConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	var filters []caskadht.AddrFilter
+	if cfg.PubliclyDialable == nil || *cfg.PubliclyDialable {
+		filters = append(filters, caskadht.PubliclyDialableAddrFilter)
+	}
+	if len(cfg.AllowCIDRs) != 0 || len(cfg.DenyCIDRs) != 0 {
+		f, err := caskadht.NewCIDRAddrFilter(cfg.AllowCIDRs, cfg.DenyCIDRs)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if len(cfg.Transports) != 0 {
+		f, err := caskadht.NewTransportAddrFilter(cfg.Transports...)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if cfg.Relay != "" {
+		f, err := caskadht.NewRelayAddrFilter(cfg.Relay)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if len(cfg.AllowDNSSuffixes) != 0 || len(cfg.DenyDNSSuffixes) != 0 {
+		filters = append(filters, caskadht.NewDNSSuffixAddrFilter(cfg.AllowDNSSuffixes, cfg.DenyDNSSuffixes))
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return caskadht.AllAddrFilters(filters...), nil
+}
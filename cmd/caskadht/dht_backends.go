@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// dhtBackend is the JSON representation of an additional DHT backend, e.g.:
+//
+//	[
+//	  {
+//	    "label": "my-private-dht",
+//	    "protocolPrefix": "/my",
+//	    "useAcceleratedDHT": false,
+//	    "bootstrapPeers": ["/ip4/10.0.0.1/tcp/4001/p2p/12D3KooW..."]
+//	  }
+//	]
+type dhtBackend struct {
+	Label             string   `json:"label"`
+	ProtocolPrefix    string   `json:"protocolPrefix"`
+	UseAcceleratedDHT bool     `json:"useAcceleratedDHT"`
+	BootstrapPeers    []string `json:"bootstrapPeers"`
+
+	bootstrapPeers []peer.AddrInfo
+}
+
+func loadDHTBackends(path string) ([]dhtBackend, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var backends []dhtBackend
+	if err := json.Unmarshal(data, &backends); err != nil {
+		return nil, err
+	}
+	for i := range backends {
+		b := &backends[i]
+		if b.ProtocolPrefix == "" {
+			b.ProtocolPrefix = "/ipfs"
+		}
+		if b.bootstrapPeers, err = parseBootstrapPeers(b.BootstrapPeers); err != nil {
+			return nil, fmt.Errorf("DHT backend %s: %w", b.Label, err)
+		}
+	}
+	return backends, nil
+}
+
+func parseBootstrapPeers(ss []string) ([]peer.AddrInfo, error) {
+	addrs := make([]multiaddr.Multiaddr, 0, len(ss))
+	for _, s := range ss {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer multiaddr %s: %w", s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return peer.AddrInfosFromP2pAddrs(addrs...)
+}
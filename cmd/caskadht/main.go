@@ -7,12 +7,18 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	leveldb "github.com/ipfs/go-ds-leveldb"
 	"github.com/ipfs/go-log/v2"
 	caskadht "github.com/ipni/caskadht"
 	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 )
@@ -28,16 +34,60 @@ const (
 func main() {
 	libp2pIdentityPath := flag.String("libp2pIdentityPath", "", "The path to the marshalled libp2p host identity. If unspecified a random identity is generated.")
 	libp2pListenAddrs := flag.String("libp2pListenAddrs", "", "The comma separated libp2p host listen multiaddrs. If unspecified the default listen multiaddrs are used at ephemeral port.")
+	libp2pPSKPath := flag.String("libp2pPSKPath", "", "The path to the libp2p pre-shared key file of the private network to join. If unspecified the host joins the public network.")
 	libp2pConMgrLow := flag.Int("libp2pConMgrLow", 160, "The low watermark of libp2p connection manager.")
 	libp2pConMgrHigh := flag.Int("libp2pConMgrHigh", 192, "The high watermark of libp2p connection manager.")
 	httpListenAddr := flag.String("httpListenAddr", "0.0.0.0:40080", "The caskadht HTTP server listen address in address:port format.")
 	metricsListenAddr := flag.String("metricsListenAddr", "0.0.0.0:40081", "The caskadht HTTP metrics listen address in address:port format.")
+	adminListenAddr := flag.String("adminListenAddr", "127.0.0.1:40083", "The caskadht HTTP admin listen address in address:port format. The admin server is unauthenticated and should only be reachable by operators.")
 	httpResponsePreferJson := flag.Bool("httpResponsePreferJson", false, `Whether to prefer responding with JSON instead of NDJSON when Accept header is set to "*/*".`)
+	dhtProtocolPrefix := flag.String("dhtProtocolPrefix", "/ipfs", "The protocol prefix of the DHT onto which lookups are cascaded.")
+	dhtBootstrapPeers := flag.String("dhtBootstrapPeers", "", "The comma separated multiaddrs, including peer ID, of the peers used to bootstrap the DHT clients. If unspecified the IPFS DHT default bootstrap peers are used; must be specified when dhtProtocolPrefix is not \"/ipfs\".")
+	dhtProviderSourceDisabled := flag.Bool("dhtProviderSourceDisabled", false, "Whether to not find providers on the DHT, in which case lookups are cascaded only onto the upstreams. The DHT is still used to discover the addrs of providers that have none.")
+	dhtMode := flag.String("dhtMode", "client", `The mode of the standard DHT client. One of "client", "server" or "auto".`)
+	dhtBucketSize := flag.Int("dhtBucketSize", 0, "The bucket size of the standard DHT client routing table. Zero uses the DHT default; must be left unset for the IPFS DHT.")
+	dhtConcurrency := flag.Int("dhtConcurrency", 0, "The number of concurrent queries per lookup, i.e. alpha, of the standard DHT client. Zero uses the DHT default.")
+	dhtResiliency := flag.Int("dhtResiliency", 0, "The number of closest peers that must respond for a lookup to complete, i.e. beta, of the standard DHT client. Zero uses the DHT default.")
+	dhtRoutingTableRefreshPeriod := flag.Duration("dhtRoutingTableRefreshPeriod", 0, "The routing table refresh interval of the standard DHT client. Zero uses the DHT default.")
 	useAcceleratedDHT := flag.Bool("useAcceleratedDHT", true, "Weather to use accelerated DHT client when possible.")
+	dhtClientSelection := flag.String("dhtClientSelection", string(caskadht.DHTClientSelectionReady), `How to select the DHT client used for lookups when the accelerated DHT client is used. One of "ready", i.e. the accelerated client once ready, "race", i.e. both clients concurrently, or "adaptive", i.e. the currently better performing client.`)
+	dhtClientAdaptiveWindow := flag.Int("dhtClientAdaptiveWindow", 100, `The number of most recent lookups per DHT client over which their performance is compared when using "adaptive" DHT client selection.`)
+	dhtClientAdaptiveExploration := flag.Float64("dhtClientAdaptiveExploration", 0.05, `The fraction of lookups sent to the worse performing DHT client when using "adaptive" DHT client selection.`)
+	accDHTCrawlInterval := flag.Duration("accDHTCrawlInterval", 0, "The interval at which the accelerated DHT client crawls the DHT to refresh its routing table. Zero uses the accelerated DHT client default.")
+	accDHTCrawlerParallelism := flag.Int("accDHTCrawlerParallelism", 200, "The number of concurrent queries made by the accelerated DHT client while crawling the DHT.")
+	accDHTCrawlerConnectTimeout := flag.Duration("accDHTCrawlerConnectTimeout", 0, "The timeout of connecting to a peer while crawling the DHT by the accelerated DHT client. Zero uses the crawler default.")
+	accDHTCrawlerMsgTimeout := flag.Duration("accDHTCrawlerMsgTimeout", 0, "The timeout of a single DHT message while crawling the DHT by the accelerated DHT client. Zero uses the crawler default.")
+	accDHTBulkSendParallelism := flag.Int("accDHTBulkSendParallelism", 0, "The maximum number of concurrent messages sent by the accelerated DHT client for bulk operations. Zero uses the accelerated DHT client default.")
+	accDHTTimeoutPerOperation := flag.Duration("accDHTTimeoutPerOperation", 0, "The timeout of each operation made by the accelerated DHT client. Zero uses the accelerated DHT client default.")
 	useResourceManager := flag.Bool("useResourceManager", true, "Weather to use resource manager with built-in increased limits. When disabled Resource Manager is completely disabled.")
 	ipniRequireQueryParam := flag.Bool("ipniRequireQueryParam", false, `Weather to require IPNI "cascade" query parameter with matching label in order to respond to HTTP lookup requests. Not required by default.`)
+	dhtBackendsPath := flag.String("dhtBackendsPath", "", "The path to the JSON file listing additional DHT backends, each with its own IPNI cascade label, protocol prefix, bootstrap peers and accelerated DHT client setting. Lookups are routed to the backend matching the IPNI \"cascade\" query parameter.")
+	delegatedRoutingUpstreams := flag.String("delegatedRoutingUpstreams", "", "The comma separated base URLs of upstream delegated routing HTTP endpoints to which lookups are fanned out in addition to the DHT. Each URL may be suffixed with \"#<timeout>\" to override the default timeout, e.g. \"https://delegated-ipfs.dev#3s\".")
+	delegatedRoutingUpstreamTimeout := flag.Duration("delegatedRoutingUpstreamTimeout", 10*time.Second, "The default timeout of requests to upstream delegated routing HTTP endpoints.")
+	ipniUpstreamsFlag := flag.String("ipniUpstreams", "", "The comma separated base URLs of upstream IPNI indexers to which lookups are fanned out in addition to the DHT. Each URL may be suffixed with \"#<timeout>\" to override the default timeout, e.g. \"https://cid.contact#3s\".")
+	ipniUpstreamTimeout := flag.Duration("ipniUpstreamTimeout", 10*time.Second, "The default timeout of requests to upstream IPNI indexers.")
 	ipniCascadeLabel := flag.String("ipniCascadeLabel", "ipfs-dht", "The IPNI cascade label associated to this instance.")
 	findProvidersLimit := flag.Int("findProvidersLimit", 0, "The maximum number of provider records to find. Defaults to zero, i.e. no limit.")
+	resultCacheMaxSize := flag.Int("resultCacheMaxSize", 0, "The maximum number of multihashes for which found providers are cached. Zero or negative disables the cache, which is disabled by default.")
+	resultCacheMaxAge := flag.Duration("resultCacheMaxAge", 5*time.Minute, "The maximum duration for which found providers are cached.")
+	resultCacheNegativeMaxAge := flag.Duration("resultCacheNegativeMaxAge", 0, "The maximum duration for which lookups that found no providers are cached. Zero or negative disables caching of such lookups, which is disabled by default.")
+	resultCacheStaleWhileRevalidate := flag.Duration("resultCacheStaleWhileRevalidate", 0, "The duration past max age during which cached providers are served while being refreshed in the background. Disabled by default.")
+	resultCacheMaxRevalidations := flag.Int("resultCacheMaxRevalidations", 16, "The maximum number of concurrent background refreshes of stale cached providers.")
+	datastorePath := flag.String("datastorePath", "", "The path to the LevelDB datastore in which cached providers and peer addrs are persisted across restarts. Requires a positive resultCacheMaxSize. If unspecified nothing is persisted.")
+	groupCacheSelf := flag.String("groupCacheSelf", "", "The base URL of this instance's group cache server, used to share lookup results with groupCachePeers. If unspecified group cache is disabled.")
+	groupCachePeers := flag.String("groupCachePeers", "", "The comma separated base URLs of group cache servers of other caskadht replicas with which lookup results are shared.")
+	groupCacheListenAddr := flag.String("groupCacheListenAddr", "0.0.0.0:40082", "The group cache server listen address in address:port format. The server is unauthenticated and should only be reachable by other replicas.")
+	denylistPaths := flag.String("denylistPaths", "", "The comma separated paths to denylist files in the compact denylist (IPIP-383) or double-hashed badbits format. Lookups for denylisted content are rejected with status 410 Gone. The files are reloaded on change or SIGHUP. If unspecified no content is blocked.")
+	delegatedRoutingSchema := flag.String("delegatedRoutingSchema", string(caskadht.DelegatedRoutingSchemaBitswap), `The default schema of delegated routing response records: "peer" or "bitswap". Clients may override it per request via the "schema" query parameter.`)
+	addrFilterConfigPath := flag.String("addrFilterConfigPath", "", "The path to the JSON file configuring the policies that provider addrs must pass to be included in lookup results: publiclyDialable, allowCIDRs, denyCIDRs, transports, relay and allowDNSSuffixes/denyDNSSuffixes. If unspecified only publicly dialable addrs are included.")
+	peerFilterPath := flag.String("peerFilterPath", "", "The path to the file listing the peer IDs of providers to exclude from lookup results, one per line. The file is reloaded on change or SIGHUP, and rewritten when updated via the /admin/peer-filter endpoint of the admin server. If unspecified no providers are excluded.")
+	peerFilterCheckInterval := flag.Duration("peerFilterCheckInterval", 10*time.Second, "The interval at which the peer filter file is checked for changes.")
+	peerFilterAllowlist := flag.Bool("peerFilterAllowlist", false, "Whether to only include the providers listed by the peer filter in lookup results instead of excluding them.")
+	httpProvideEnabled := flag.Bool("httpProvideEnabled", false, "Whether to accept signed provider records via PUT /routing/v1/providers. Accepted records are persisted in the datastore if any, and served in the lookup results of this instance only; they are not announced to the DHT.")
+	httpProvideMaxTTL := flag.Duration("httpProvideMaxTTL", 48*time.Hour, "The maximum duration for which provider records accepted via PUT /routing/v1/providers are kept, counted from their timestamp.")
+	httpProvideMaxPerPeer := flag.Int("httpProvideMaxPerPeer", 1024, "The maximum number of keys kept per provider from records accepted via PUT /routing/v1/providers.")
+	httpProvideMaxRecords := flag.Int("httpProvideMaxRecords", 100_000, "The maximum number of keys kept across all providers from records accepted via PUT /routing/v1/providers.")
+	staticProvidersPath := flag.String("staticProvidersPath", "", "The path to the JSON or YAML file mapping multihashes, CIDs or prefixes thereof suffixed with \"*\" to providers that are served ahead of lookup results. The file is reloaded on change or SIGHUP. If unspecified no static providers are served.")
 	logLevel := flag.String("logLevel", "info", "The logging level. Only applied if GOLOG_LOG_LEVEL environment variable is unset.")
 	flag.Parse()
 
@@ -63,10 +113,32 @@ func main() {
 		}
 		hOpts = append(hOpts, libp2p.Identity(id))
 	}
+	if *libp2pPSKPath != "" {
+		p := filepath.Clean(*libp2pPSKPath)
+		logger := logger.With("path", p)
+		logger.Info("Decoding libp2p pre-shared key")
+		psk, err := loadPSK(p)
+		if err != nil {
+			logger.Fatalw("Failed to load libp2p pre-shared key file", "err", err)
+		}
+		hOpts = append(hOpts, libp2p.PrivateNetwork(psk))
+	}
 	if *libp2pListenAddrs != "" {
 		hOpts = append(hOpts, libp2p.ListenAddrStrings(strings.Split(*libp2pListenAddrs, ",")...))
 	}
-	if *useAcceleratedDHT && *useResourceManager {
+	var backends []dhtBackend
+	if *dhtBackendsPath != "" {
+		p := filepath.Clean(*dhtBackendsPath)
+		var err error
+		if backends, err = loadDHTBackends(p); err != nil {
+			logger.Fatalw("Failed to load DHT backends", "path", p, "err", err)
+		}
+	}
+	anyAcceleratedDHT := *useAcceleratedDHT
+	for _, b := range backends {
+		anyAcceleratedDHT = anyAcceleratedDHT || b.UseAcceleratedDHT
+	}
+	if anyAcceleratedDHT && *useResourceManager {
 		// Adjust outbound connections and base limit FD to allow the accelerated DHT client to
 		// (re)load its routing table. Because, currently the client does not gracefully handle
 		// Resource Manager throttling.
@@ -109,16 +181,133 @@ func main() {
 		logger.Fatalw("Failed to instantiate libp2p host", "err", err)
 	}
 
-	c, err := caskadht.New(
+	var mode dht.ModeOpt
+	switch *dhtMode {
+	case "client":
+		mode = dht.ModeClient
+	case "server":
+		mode = dht.ModeServer
+	case "auto":
+		mode = dht.ModeAuto
+	default:
+		logger.Fatalw("Unknown DHT mode", "mode", *dhtMode)
+	}
+
+	var bootstrapPeers []peer.AddrInfo
+	if *dhtBootstrapPeers != "" {
+		if bootstrapPeers, err = parseBootstrapPeers(strings.Split(*dhtBootstrapPeers, ",")); err != nil {
+			logger.Fatalw("Failed to parse DHT bootstrap peers", "err", err)
+		}
+	}
+
+	cOpts := []caskadht.Option{
 		caskadht.WithHost(h),
 		caskadht.WithHttpListenAddr(*httpListenAddr),
 		caskadht.WithMetricsListenAddr(*metricsListenAddr),
+		caskadht.WithAdminListenAddr(*adminListenAddr),
+		caskadht.WithDHTProtocolPrefix(protocol.ID(*dhtProtocolPrefix)),
+		caskadht.WithBootstrapPeers(bootstrapPeers...),
+		caskadht.WithDHTMode(mode),
+		caskadht.WithDHTProviderSourceDisabled(*dhtProviderSourceDisabled),
+		caskadht.WithDHTBucketSize(*dhtBucketSize),
+		caskadht.WithDHTConcurrency(*dhtConcurrency),
+		caskadht.WithDHTResiliency(*dhtResiliency),
+		caskadht.WithDHTRoutingTableRefreshPeriod(*dhtRoutingTableRefreshPeriod),
 		caskadht.WithUseAcceleratedDHT(*useAcceleratedDHT),
+		caskadht.WithAccDHTCrawlInterval(*accDHTCrawlInterval),
+		caskadht.WithAccDHTCrawlerParallelism(*accDHTCrawlerParallelism),
+		caskadht.WithAccDHTCrawlerConnectTimeout(*accDHTCrawlerConnectTimeout),
+		caskadht.WithAccDHTCrawlerMsgTimeout(*accDHTCrawlerMsgTimeout),
+		caskadht.WithAccDHTBulkSendParallelism(*accDHTBulkSendParallelism),
+		caskadht.WithAccDHTTimeoutPerOperation(*accDHTTimeoutPerOperation),
+		caskadht.WithDHTClientSelection(caskadht.DHTClientSelection(*dhtClientSelection)),
+		caskadht.WithDHTClientAdaptiveWindow(*dhtClientAdaptiveWindow),
+		caskadht.WithDHTClientAdaptiveExploration(*dhtClientAdaptiveExploration),
 		caskadht.WithIpniCascadeLabel(*ipniCascadeLabel),
 		caskadht.WithIpniRequireCascadeQueryParam(*ipniRequireQueryParam),
 		caskadht.WithHttpResponsePreferJson(*httpResponsePreferJson),
+		caskadht.WithDelegatedRoutingSchema(caskadht.DelegatedRoutingSchema(*delegatedRoutingSchema)),
 		caskadht.WithFindProvidersLimit(*findProvidersLimit),
-	)
+		caskadht.WithResultCacheMaxSize(*resultCacheMaxSize),
+		caskadht.WithResultCacheMaxAge(*resultCacheMaxAge),
+		caskadht.WithResultCacheNegativeMaxAge(*resultCacheNegativeMaxAge),
+		caskadht.WithResultCacheStaleWhileRevalidate(*resultCacheStaleWhileRevalidate),
+		caskadht.WithResultCacheMaxRevalidations(*resultCacheMaxRevalidations),
+	}
+	for _, b := range backends {
+		cOpts = append(cOpts, caskadht.WithDHTBackend(b.Label, protocol.ID(b.ProtocolPrefix), b.UseAcceleratedDHT, b.bootstrapPeers...))
+	}
+	drUpstreams, err := parseUpstreams(*delegatedRoutingUpstreams, *delegatedRoutingUpstreamTimeout)
+	if err != nil {
+		logger.Fatalw("Failed to parse delegated routing upstreams", "err", err)
+	}
+	for _, u := range drUpstreams {
+		cOpts = append(cOpts, caskadht.WithDelegatedRoutingUpstream(u.url, u.timeout))
+	}
+	ipniUpstreams, err := parseUpstreams(*ipniUpstreamsFlag, *ipniUpstreamTimeout)
+	if err != nil {
+		logger.Fatalw("Failed to parse IPNI upstreams", "err", err)
+	}
+	for _, u := range ipniUpstreams {
+		cOpts = append(cOpts, caskadht.WithIpniUpstream(u.url, u.timeout))
+	}
+	if *groupCacheSelf != "" {
+		var peers []string
+		if *groupCachePeers != "" {
+			peers = strings.Split(*groupCachePeers, ",")
+		}
+		cOpts = append(cOpts,
+			caskadht.WithGroupCache(*groupCacheSelf, peers...),
+			caskadht.WithGroupCacheListenAddr(*groupCacheListenAddr),
+		)
+	}
+	if *datastorePath != "" {
+		p := filepath.Clean(*datastorePath)
+		ds, err := leveldb.NewDatastore(p, nil)
+		if err != nil {
+			logger.Fatalw("Failed to instantiate datastore", "path", p, "err", err)
+		}
+		defer func() {
+			if err := ds.Close(); err != nil {
+				logger.Warnw("Failed to close datastore", "err", err)
+			}
+		}()
+		cOpts = append(cOpts, caskadht.WithDatastore(ds))
+	}
+	if *denylistPaths != "" {
+		for _, p := range strings.Split(*denylistPaths, ",") {
+			cOpts = append(cOpts, caskadht.WithDenylistPaths(filepath.Clean(p)))
+		}
+	}
+	if *addrFilterConfigPath != "" {
+		p := filepath.Clean(*addrFilterConfigPath)
+		addrFilter, err := loadAddrFilter(p)
+		if err != nil {
+			logger.Fatalw("Failed to load addr filter config", "path", p, "err", err)
+		}
+		cOpts = append(cOpts, caskadht.WithAddrFilter(addrFilter))
+	}
+	if *peerFilterPath != "" {
+		cOpts = append(cOpts,
+			caskadht.WithPeerFilterPath(filepath.Clean(*peerFilterPath)),
+			caskadht.WithPeerFilterCheckInterval(*peerFilterCheckInterval),
+		)
+	}
+	if *peerFilterAllowlist {
+		cOpts = append(cOpts, caskadht.WithPeerFilterAllowlist(true))
+	}
+	if *httpProvideEnabled {
+		cOpts = append(cOpts,
+			caskadht.WithHttpProvideEnabled(true),
+			caskadht.WithHttpProvideMaxTTL(*httpProvideMaxTTL),
+			caskadht.WithHttpProvideMaxPerPeer(*httpProvideMaxPerPeer),
+			caskadht.WithHttpProvideMaxRecords(*httpProvideMaxRecords),
+		)
+	}
+	if *staticProvidersPath != "" {
+		cOpts = append(cOpts, caskadht.WithStaticProvidersPath(filepath.Clean(*staticProvidersPath)))
+	}
+	c, err := caskadht.New(cOpts...)
 	if err != nil {
 		logger.Fatalw("Failed to instantiate caskadht", "err", err)
 	}
@@ -128,8 +317,28 @@ func main() {
 	}
 	sch := make(chan os.Signal, 1)
 	signal.Notify(sch, os.Interrupt)
+	hch := make(chan os.Signal, 1)
+	signal.Notify(hch, syscall.SIGHUP)
 
-	<-sch
+	for waiting := true; waiting; {
+		select {
+		case <-hch:
+			logger.Info("Reloading static providers...")
+			if err := c.ReloadStaticProviders(); err != nil {
+				logger.Errorw("Failed to reload static providers", "err", err)
+			}
+			logger.Info("Reloading denylist...")
+			if err := c.ReloadDenylist(); err != nil {
+				logger.Errorw("Failed to reload denylist", "err", err)
+			}
+			logger.Info("Reloading peer filter...")
+			if err := c.ReloadPeerFilter(); err != nil {
+				logger.Errorw("Failed to reload peer filter", "err", err)
+			}
+		case <-sch:
+			waiting = false
+		}
+	}
 	logger.Info("Terminating...")
 	if err := c.Shutdown(ctx); err != nil {
 		logger.Warnw("Failure occurred while shutting down server.", "err", err)
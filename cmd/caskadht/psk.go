@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// loadPSK decodes the libp2p pre-shared key of a private network from the file at the given path,
+// in the format generated by ipfs-swarm-key-gen.
+func loadPSK(path string) (pnet.PSK, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return pnet.DecodeV1PSK(f)
+}
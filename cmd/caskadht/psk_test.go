@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadPSK(t *testing.T) {
+	dir := t.TempDir()
+	fishPath := writePSK(t, dir, "fish", 0x01)
+	lobsterPath := writePSK(t, dir, "lobster", 0x02)
+
+	fish, err := loadPSK(fishPath)
+	require.NoError(t, err)
+	require.Len(t, fish, 32)
+	lobster, err := loadPSK(lobsterPath)
+	require.NoError(t, err)
+	require.NotEqual(t, fish, lobster)
+
+	_, err = loadPSK(filepath.Join(dir, "missing"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	invalidPath := filepath.Join(dir, "invalid")
+	require.NoError(t, os.WriteFile(invalidPath, []byte("fish"), 0o600))
+	_, err = loadPSK(invalidPath)
+	require.Error(t, err)
+
+	newHost := func(t *testing.T, path string) host.Host {
+		psk, err := loadPSK(path)
+		require.NoError(t, err)
+		h, err := libp2p.New(libp2p.PrivateNetwork(psk), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = h.Close() })
+		return h
+	}
+	connect := func(from, to host.Host) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return from.Connect(ctx, peer.AddrInfo{ID: to.ID(), Addrs: to.Addrs()})
+	}
+
+	t.Run("same key", func(t *testing.T) {
+		a := newHost(t, fishPath)
+		b := newHost(t, fishPath)
+		require.NoError(t, connect(a, b))
+	})
+	t.Run("mismatched key", func(t *testing.T) {
+		a := newHost(t, fishPath)
+		b := newHost(t, lobsterPath)
+		require.Error(t, connect(a, b))
+	})
+}
+
+func writePSK(t *testing.T, dir, name string, b byte) string {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte("/key/swarm/psk/1.0.0/\n/base16/\n"+hex.EncodeToString(key)+"\n"), 0o600))
+	return path
+}
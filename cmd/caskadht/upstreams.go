@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type upstream struct {
+	url     string
+	timeout time.Duration
+}
+
+// parseUpstreams parses the given comma separated upstream URLs, each optionally suffixed with
+// "#<timeout>". The URL fragment is never sent to servers, which makes it a safe separator.
+func parseUpstreams(list string, defaultTimeout time.Duration) ([]upstream, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var upstreams []upstream
+	for _, s := range strings.Split(list, ",") {
+		u := upstream{url: s, timeout: defaultTimeout}
+		if url, timeout, found := strings.Cut(s, "#"); found {
+			var err error
+			u.url = url
+			if u.timeout, err = time.ParseDuration(timeout); err != nil {
+				return nil, fmt.Errorf("invalid timeout for upstream %s: %w", url, err)
+			}
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams, nil
+}
@@ -0,0 +1,271 @@
+package caskadht
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	denylistAPIIpni             = "ipni"
+	denylistAPIDelegatedRouting = "delegated-routing"
+)
+
+type (
+	// denylist blocks lookups for content listed in local denylist files, in either the compact
+	// denylist format specified by IPIP-383 or the legacy double-hashed badbits format, e.g.:
+	//
+	//	version: 1
+	//	name: Example denylist
+	//	---
+	//	# Block a CID; only its multihash is considered.
+	//	/ipfs/bafybeihvvulpp4evxj7x7armbqcyg6uezzuig6jp3lktpbovlqfkuqeuoq
+	//	# Block the same CID double-hashed, i.e. multihash of the base58 encoded multihash of the CID.
+	//	//Qmc1iBtNp46AeeYWGWhKQuqUYJHReZFzzgteRCzWtjAxdu
+	//	# Block the same CID legacy double-hashed, i.e. hex encoded sha256 of "<CIDv1 base32>/".
+	//	//b2d7e94531bf82a31184cbbdeaa63f620657913377301c2b4f31e6473ad3f366
+	//	# Unblock a CID blocked by an earlier rule.
+	//	!/ipfs/bafybeihvvulpp4evxj7x7armbqcyg6uezzuig6jp3lktpbovlqfkuqeuoq
+	//
+	// Rules that block paths, IPNS names, or other content not addressable by a lookup key are
+	// ignored.
+	denylist struct {
+		paths []string
+
+		lock     sync.RWMutex
+		modTimes map[string]time.Time
+		rules    *denylistRules
+	}
+	denylistRules struct {
+		// multihashes are the blocked multihashes, keyed by their bytes.
+		multihashes map[string]struct{}
+		// doubleHashes are the blocked double-hashed multihashes, keyed by their bytes.
+		doubleHashes map[string]struct{}
+		// doubleHashCodes are the distinct hash functions used by doubleHashes.
+		doubleHashCodes []uint64
+		// legacyHashes are the blocked legacy double-hashes, keyed by their hex encoding.
+		legacyHashes map[string]struct{}
+	}
+)
+
+func newDenylist(paths []string) *denylist {
+	return &denylist{
+		paths: paths,
+	}
+}
+
+// load (re)loads the denylist from all its files. The currently loaded rules are kept if any of
+// the files cannot be loaded.
+func (d *denylist) load() error {
+	rules := &denylistRules{
+		multihashes:  make(map[string]struct{}),
+		doubleHashes: make(map[string]struct{}),
+		legacyHashes: make(map[string]struct{}),
+	}
+	modTimes := make(map[string]time.Time, len(d.paths))
+	for _, path := range d.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := rules.load(path); err != nil {
+			return fmt.Errorf("failed to load denylist %s: %w", path, err)
+		}
+		modTimes[path] = info.ModTime()
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.rules = rules
+	d.modTimes = modTimes
+	logger.Infow("Loaded denylist", "files", len(d.paths), "multihashes", len(rules.multihashes), "doubleHashes", len(rules.doubleHashes)+len(rules.legacyHashes))
+	return nil
+}
+
+// reloadIfChanged reloads the denylist if any of its files has been modified since last loaded.
+func (d *denylist) reloadIfChanged() error {
+	var changed bool
+	d.lock.RLock()
+	for _, path := range d.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			d.lock.RUnlock()
+			return err
+		}
+		if !info.ModTime().Equal(d.modTimes[path]) {
+			changed = true
+			break
+		}
+	}
+	d.lock.RUnlock()
+	if !changed {
+		return nil
+	}
+	return d.load()
+}
+
+// blocked checks whether lookups for the given key are blocked.
+func (d *denylist) blocked(key cid.Cid) bool {
+	d.lock.RLock()
+	rules := d.rules
+	d.lock.RUnlock()
+	return rules.blocked(key)
+}
+
+func (r *denylistRules) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines []string
+	var headerEnd int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Everything up to the first document separator, if any, is the YAML header.
+		if line == "---" && headerEnd == 0 {
+			headerEnd = len(lines) + 1
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, line := range lines[headerEnd:] {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := r.apply(line); err != nil {
+			logger.Warnw("Skipping invalid denylist rule", "path", path, "rule", line, "err", err)
+		}
+	}
+	return nil
+}
+
+func (r *denylistRules) apply(rule string) error {
+	rule, allow := strings.CutPrefix(rule, "!")
+	switch {
+	case strings.HasPrefix(rule, "//"):
+		hash := strings.TrimPrefix(rule, "//")
+		if len(hash) == sha256.Size*2 {
+			if _, err := hex.DecodeString(hash); err == nil {
+				r.set(r.legacyHashes, strings.ToLower(hash), allow)
+				return nil
+			}
+		}
+		mh, err := multihash.FromB58String(hash)
+		if err != nil {
+			return err
+		}
+		dm, err := multihash.Decode(mh)
+		if err != nil {
+			return err
+		}
+		r.set(r.doubleHashes, string(mh), allow)
+		for _, code := range r.doubleHashCodes {
+			if code == dm.Code {
+				return nil
+			}
+		}
+		r.doubleHashCodes = append(r.doubleHashCodes, dm.Code)
+	case strings.HasPrefix(rule, "/ipfs/"):
+		id, subpath, _ := strings.Cut(strings.TrimPrefix(rule, "/ipfs/"), "/")
+		if subpath != "" {
+			// Rules blocking paths within the CID do not block lookups of the CID itself.
+			return nil
+		}
+		c, err := cid.Decode(id)
+		if err != nil {
+			return err
+		}
+		r.set(r.multihashes, string(c.Hash()), allow)
+	}
+	return nil
+}
+
+func (r *denylistRules) set(m map[string]struct{}, key string, allow bool) {
+	if allow {
+		delete(m, key)
+	} else {
+		m[key] = struct{}{}
+	}
+}
+
+func (r *denylistRules) blocked(key cid.Cid) bool {
+	if r == nil {
+		return false
+	}
+	if _, found := r.multihashes[string(key.Hash())]; found {
+		return true
+	}
+	if len(r.doubleHashes) != 0 {
+		b58 := []byte(key.Hash().B58String())
+		for _, code := range r.doubleHashCodes {
+			dh, err := multihash.Sum(b58, code, -1)
+			if err != nil {
+				continue
+			}
+			if _, found := r.doubleHashes[string(dh)]; found {
+				return true
+			}
+		}
+	}
+	if len(r.legacyHashes) != 0 {
+		v1, err := cid.NewCidV1(key.Type(), key.Hash()).StringOfBase(multibase.Base32)
+		if err == nil {
+			sum := sha256.Sum256([]byte(v1 + "/"))
+			if _, found := r.legacyHashes[hex.EncodeToString(sum[:])]; found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// blocked checks whether lookups for the given key are blocked by the denylist, if configured,
+// recording the blocked lookups for the given API.
+func (c *Caskadht) blocked(ctx context.Context, key cid.Cid, api string) bool {
+	if c.denylist == nil || !c.denylist.blocked(key) {
+		return false
+	}
+	logger.Debugw("Rejected lookup for denylisted key", "key", key, "api", api)
+	c.metrics.notifyDenylistBlocked(ctx, api)
+	return true
+}
+
+// ReloadDenylist reloads the denylist from its files, if configured.
+// See: WithDenylistPaths.
+func (c *Caskadht) ReloadDenylist() error {
+	if c.denylist == nil {
+		return nil
+	}
+	return c.denylist.load()
+}
+
+// watchDenylist periodically reloads the denylist if any of its files has changed, until the
+// server is shut down.
+func (c *Caskadht) watchDenylist() {
+	ticker := time.NewTicker(c.denylistCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.denylist.reloadIfChanged(); err != nil {
+				logger.Errorw("Failed to reload denylist", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package caskadht
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_denylist(t *testing.T) {
+	newCid := func(s string) cid.Cid {
+		mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(cid.Raw, mh)
+	}
+	fish := newCid("fish")
+	lobster := newCid("lobster")
+	crab := newCid("crab")
+	squid := newCid("squid")
+	// Legacy CIDv0 keys must match rules on their CIDv1 equivalent.
+	fishV0 := cid.NewCidV0(fish.Hash())
+
+	doubleHash, err := multihash.Sum([]byte(lobster.Hash().B58String()), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	crabV1, err := crab.StringOfBase(multibase.Base32)
+	require.NoError(t, err)
+	legacyHash := sha256.Sum256([]byte(crabV1 + "/"))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deny.txt")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`version: 1
+name: test
+---
+# Comments and blank lines are ignored.
+
+/ipfs/%s
+//%s
+//%s
+/ipfs/%s/some/path
+/ipfs/%s
+!/ipfs/%s
+/ipns/example.com
+/ipfs/not-a-cid
+`, fish, doubleHash.B58String(), hex.EncodeToString(legacyHash[:]), squid, squid, squid)), 0o600))
+
+	subject := newDenylist([]string{path})
+	require.NoError(t, subject.load())
+	require.True(t, subject.blocked(fish))
+	require.True(t, subject.blocked(fishV0))
+	require.True(t, subject.blocked(lobster))
+	require.True(t, subject.blocked(crab))
+	require.False(t, subject.blocked(squid))
+
+	// Change the file and assert it is reloaded.
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("/ipfs/%s\n", squid)), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.NoError(t, subject.reloadIfChanged())
+	require.False(t, subject.blocked(fish))
+	require.False(t, subject.blocked(lobster))
+	require.False(t, subject.blocked(crab))
+	require.True(t, subject.blocked(squid))
+
+	// Missing files must not replace the loaded rules.
+	require.NoError(t, os.Remove(path))
+	require.Error(t, subject.reloadIfChanged())
+	require.True(t, subject.blocked(squid))
+}
@@ -0,0 +1,184 @@
+package caskadht
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// dhtBackend is a DHT onto which lookups are cascaded, identified by its IPNI cascade label. Each
+// backend has its own DHT clients, in-flight lookups and cached results.
+type dhtBackend struct {
+	label          string
+	protocolPrefix protocol.ID
+	bootstrapPeers []peer.AddrInfo
+	useAccDHT      bool
+
+	std         *dht.IpfsDHT
+	acc         *fullrt.FullRT
+	selector    *dhtClientSelector
+	lookups     *lookupGroup
+	resultCache *providerResultCache
+	resultStore *resultStore
+	provides    *provideStore
+}
+
+// dhtBackendNamespace is the datastore namespace under which the results of additional DHT
+// backends are persisted. The results of the default backend are persisted at the root for
+// backward compatibility.
+const dhtBackendNamespace = "/backends"
+
+func (c *Caskadht) newDHTBackend(cfg dhtBackendConfig, primary bool) (*dhtBackend, error) {
+	selector, err := newDHTClientSelector(c.dhtClientAdaptiveWindow, c.dhtClientAdaptiveExploration)
+	if err != nil {
+		return nil, err
+	}
+	b := &dhtBackend{
+		label:          cfg.label,
+		protocolPrefix: cfg.protocolPrefix,
+		bootstrapPeers: cfg.bootstrapPeers,
+		useAccDHT:      cfg.useAccDHT,
+		selector:       selector,
+		lookups:        newLookupGroup(),
+	}
+	if c.resultCacheMaxSize > 0 {
+		b.resultCache = newProviderResultCache(c.resultCacheMaxSize, c.resultCacheMaxAge, c.resultCacheNegativeMaxAge, c.resultCacheStaleWhileReval)
+	}
+	if c.ds != nil {
+		var ds datastore.Datastore = c.ds
+		if !primary {
+			ds = namespace.Wrap(c.ds, datastore.NewKey(dhtBackendNamespace).ChildString(b.label))
+		}
+		b.resultStore = newResultStore(ds)
+	}
+	if c.httpProvideEnabled {
+		// Keep provides in memory if no datastore is set.
+		var ds datastore.Datastore = dssync.MutexWrap(datastore.NewMapDatastore())
+		if b.resultStore != nil {
+			ds = b.resultStore.ds
+		}
+		b.provides = newProvideStore(ds, c.httpProvideMaxPerPeer, c.httpProvideMaxRecords)
+	}
+	return b, nil
+}
+
+// backend returns the DHT backend with the given label, or nil if there is no such backend.
+func (c *Caskadht) backend(label string) *dhtBackend {
+	for _, b := range c.backends {
+		if b.label == label {
+			return b
+		}
+	}
+	return nil
+}
+
+// startDHTBackend instantiates the DHT clients of the given backend.
+func (c *Caskadht) startDHTBackend(ctx context.Context, b *dhtBackend) error {
+	var err error
+	b.std, err = dht.New(ctx, c.h, c.stdDHTOptions(b)...)
+	if err != nil {
+		return err
+	}
+	if b.useAccDHT {
+		accOpts, err := c.accDHTOptions(b)
+		if err != nil {
+			return err
+		}
+		b.acc, err = fullrt.NewFullRT(c.h, b.protocolPrefix, accOpts...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Caskadht) stdDHTOptions(b *dhtBackend) []dht.Option {
+	opts := []dht.Option{
+		dht.Mode(c.dhtMode),
+		dht.ProtocolPrefix(b.protocolPrefix),
+		dht.BootstrapPeers(b.bootstrapPeers...),
+	}
+	if c.dhtBucketSize > 0 {
+		opts = append(opts, dht.BucketSize(c.dhtBucketSize))
+	}
+	if c.dhtConcurrency > 0 {
+		opts = append(opts, dht.Concurrency(c.dhtConcurrency))
+	}
+	if c.dhtResiliency > 0 {
+		opts = append(opts, dht.Resiliency(c.dhtResiliency))
+	}
+	if c.dhtRTRefreshPeriod > 0 {
+		opts = append(opts, dht.RoutingTableRefreshPeriod(c.dhtRTRefreshPeriod))
+	}
+	for ns, v := range c.dhtValidators {
+		opts = append(opts, dht.NamespacedValidator(ns, v))
+	}
+	return opts
+}
+
+func (c *Caskadht) accDHTOptions(b *dhtBackend) ([]fullrt.Option, error) {
+	validator := record.NamespacedValidator{
+		"pk":   record.PublicKeyValidator{},
+		"ipns": ipns.Validator{KeyBook: c.h.Peerstore()},
+	}
+	for ns, v := range c.dhtValidators {
+		validator[ns] = v
+	}
+	dhtOpts := []dht.Option{
+		dht.Validator(validator),
+		dht.BootstrapPeers(b.bootstrapPeers...),
+		// The accelerated DHT client only ever operates as a client.
+		dht.Mode(dht.ModeClient),
+	}
+	if c.dhtBucketSize > 0 {
+		dhtOpts = append(dhtOpts, dht.BucketSize(c.dhtBucketSize))
+	}
+	// Instantiate the crawler explicitly, since the default crawler always uses the IPFS DHT
+	// protocol regardless of the protocol prefix.
+	crawlerOpts := []crawler.Option{
+		crawler.WithProtocols([]protocol.ID{b.protocolPrefix + "/kad/1.0.0"}),
+		crawler.WithParallelism(c.accDHTCrawlerParallelism),
+	}
+	if c.accDHTCrawlerConnectTimeout > 0 {
+		crawlerOpts = append(crawlerOpts, crawler.WithConnectTimeout(c.accDHTCrawlerConnectTimeout))
+	}
+	if c.accDHTCrawlerMsgTimeout > 0 {
+		crawlerOpts = append(crawlerOpts, crawler.WithMsgTimeout(c.accDHTCrawlerMsgTimeout))
+	}
+	dhtCrawler, err := crawler.NewDefaultCrawler(c.h, crawlerOpts...)
+	if err != nil {
+		return nil, err
+	}
+	opts := []fullrt.Option{
+		fullrt.DHTOption(dhtOpts...),
+		fullrt.WithCrawler(dhtCrawler),
+	}
+	if c.accDHTCrawlInterval > 0 {
+		opts = append(opts, fullrt.WithCrawlInterval(c.accDHTCrawlInterval))
+	}
+	if c.accDHTBulkSendParallelism > 0 {
+		opts = append(opts, fullrt.WithBulkSendParallelism(c.accDHTBulkSendParallelism))
+	}
+	if c.accDHTTimeoutPerOperation > 0 {
+		opts = append(opts, fullrt.WithTimeoutPerOperation(c.accDHTTimeoutPerOperation))
+	}
+	return opts, nil
+}
+
+func (b *dhtBackend) close() {
+	if b.std != nil {
+		_ = b.std.Close()
+	}
+	if b.acc != nil {
+		_ = b.acc.Close()
+	}
+}
@@ -0,0 +1,103 @@
+package caskadht
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_backendForRequest(t *testing.T) {
+	c := &Caskadht{
+		backends: []*dhtBackend{{label: "ipfs-dht"}, {label: "fish-dht"}},
+	}
+	tests := []struct {
+		name        string
+		target      string
+		wantLabel   string
+		wantPresent bool
+		wantMatched bool
+	}{
+		{name: "absent", target: "/multihash/fish", wantLabel: "ipfs-dht"},
+		{name: "default", target: "/multihash/fish?cascade=ipfs-dht", wantLabel: "ipfs-dht", wantPresent: true, wantMatched: true},
+		{name: "other", target: "/multihash/fish?cascade=fish-dht", wantLabel: "fish-dht", wantPresent: true, wantMatched: true},
+		{name: "any", target: "/multihash/fish?cascade=lobster&cascade=fish-dht", wantLabel: "fish-dht", wantPresent: true, wantMatched: true},
+		{name: "mismatch", target: "/multihash/fish?cascade=lobster", wantLabel: "ipfs-dht", wantPresent: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, present, matched := c.backendForRequest(httptest.NewRequest("GET", test.target, nil))
+			require.Equal(t, test.wantLabel, b.label)
+			require.Equal(t, test.wantPresent, present)
+			require.Equal(t, test.wantMatched, matched)
+		})
+	}
+}
+
+func Test_newOptionsDHTBackends(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+
+	_, err = newOptions(WithHost(h), WithDHTBackend("ipfs-dht", ipfsProtocolPrefix, false))
+	require.ErrorContains(t, err, "duplicate")
+
+	_, err = newOptions(WithHost(h), WithDHTBackend("fish-dht", "/fish", false))
+	require.ErrorContains(t, err, "bootstrap peers must be specified")
+
+	opts, err := newOptions(WithHost(h), WithDHTBackend("other-ipfs-dht", ipfsProtocolPrefix, true))
+	require.NoError(t, err)
+	require.Len(t, opts.dhtBackends, 1)
+	require.NotEmpty(t, opts.dhtBackends[0].bootstrapPeers)
+}
+
+func Test_customDHTProtocolPrefix(t *testing.T) {
+	newHost := func(t *testing.T) host.Host {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = h.Close() })
+		return h
+	}
+	ctx := context.Background()
+	h := newHost(t)
+
+	_, err := New(WithHost(h), WithDHTProtocolPrefix("/fish"))
+	require.ErrorContains(t, err, "bootstrap peers must be specified")
+
+	// Bootstrap off a member of the custom DHT, and check that a member of the IPFS DHT is
+	// not added to the routing table.
+	fishHost := newHost(t)
+	fishDHT, err := dht.New(ctx, fishHost, dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/fish"), dht.BootstrapPeers())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = fishDHT.Close() })
+	ipfsHost := newHost(t)
+	ipfsDHT, err := dht.New(ctx, ipfsHost, dht.Mode(dht.ModeServer), dht.BootstrapPeers())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ipfsDHT.Close() })
+
+	fishInfo := peer.AddrInfo{ID: fishHost.ID(), Addrs: fishHost.Addrs()}
+	c, err := New(WithHost(h), WithDHTProtocolPrefix("/fish"), WithBootstrapPeers(fishInfo), WithDHTMode(dht.ModeServer))
+	require.NoError(t, err)
+	b := c.backends[0]
+	require.Equal(t, protocol.ID("/fish"), b.protocolPrefix)
+	require.Equal(t, []peer.AddrInfo{fishInfo}, b.bootstrapPeers)
+	require.NoError(t, c.startDHTBackend(ctx, b))
+	t.Cleanup(func() { _ = b.std.Close() })
+
+	require.Contains(t, h.Mux().Protocols(), protocol.ID("/fish/kad/1.0.0"))
+	require.NotContains(t, h.Mux().Protocols(), protocol.ID("/ipfs/kad/1.0.0"))
+
+	require.NoError(t, h.Connect(ctx, fishInfo))
+	require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: ipfsHost.ID(), Addrs: ipfsHost.Addrs()}))
+	require.Eventually(t, func() bool {
+		return b.std.RoutingTable().Find(fishHost.ID()) != ""
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Empty(t, b.std.RoutingTable().Find(ipfsHost.ID()))
+}
@@ -0,0 +1,296 @@
+package caskadht
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache"
+	"github.com/golang/groupcache/consistenthash"
+	pb "github.com/golang/groupcache/groupcachepb"
+	"github.com/golang/protobuf/proto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	groupCacheBasePath = "/_groupcache/"
+	groupCacheReplicas = 50
+)
+
+var (
+	// errGroupCacheNegativeExpired signals that the group cache holds an empty result older than
+	// the negative max age, which must therefore be looked up again.
+	errGroupCacheNegativeExpired = errors.New("empty group cache result is older than negative max age")
+	errGroupCacheClosed          = errors.New("group cache is closed")
+
+	groupCacheSlotsOnce sync.Once
+	groupCacheSlotsLock sync.Mutex
+	// groupCacheSlots maps group names to their registration. Because groupcache groups can
+	// neither be unregistered nor share a name, and only a single peer picker registration is
+	// allowed per process, each group is registered once and reused by later group caches with
+	// the same self URL, e.g. across restarts of caskadht instances embedded in the same process.
+	groupCacheSlots = make(map[string]*groupCacheSlot)
+)
+
+type (
+	// groupCache shares provider lookup results across caskadht replicas using groupcache. Each
+	// key is owned by exactly one replica, chosen by consistent hashing over the static list of
+	// replica base URLs. Lookups for keys owned by other replicas are fetched from the owner,
+	// which performs the lookup on behalf of the rest.
+	groupCache struct {
+		self           string
+		maxAge         time.Duration
+		negativeMaxAge time.Duration
+		peers          *consistenthash.Map
+		getters        map[string]*groupCacheGetter
+		lookup         groupCacheLookup
+		slot           *groupCacheSlot
+		group          *groupcache.Group
+	}
+	// groupCacheSlot is the process-wide registration of a groupcache group, which delegates to
+	// the group cache currently using it, if any.
+	groupCacheSlot struct {
+		lock    sync.RWMutex
+		current *groupCache
+		group   *groupcache.Group
+	}
+	// groupCacheValue is the value of groupcache entries.
+	groupCacheValue struct {
+		Providers []peer.AddrInfo
+		// At is the time at which the providers were looked up.
+		At time.Time
+	}
+	groupCacheGetter struct {
+		client  *http.Client
+		baseURL string
+	}
+	// groupCacheLookup looks up the providers of the given multihash on the DHT backend with the
+	// given cascade label. It must only return providers if the lookup runs to completion.
+	groupCacheLookup func(context.Context, string, multihash.Multihash) ([]peer.AddrInfo, error)
+)
+
+// newGroupCache instantiates a group cache for the replica with the given self URL. At most one
+// group cache per self URL may be open in a process at a time, and the group cache must be closed
+// once no longer used. Note that the max bytes of the group registered by the first group cache
+// with the same self URL in a process are kept by later ones.
+func newGroupCache(self string, peers []string, maxBytes int64, maxAge, negativeMaxAge time.Duration, lookup groupCacheLookup) (*groupCache, error) {
+	g := &groupCache{
+		self:           self,
+		maxAge:         maxAge,
+		negativeMaxAge: negativeMaxAge,
+		peers:          consistenthash.New(groupCacheReplicas, nil),
+		getters:        make(map[string]*groupCacheGetter, len(peers)),
+		lookup:         lookup,
+	}
+	g.peers.Add(self)
+	for _, p := range peers {
+		if p == self {
+			continue
+		}
+		g.peers.Add(p)
+		g.getters[p] = &groupCacheGetter{
+			client:  http.DefaultClient,
+			baseURL: strings.TrimSuffix(p, "/") + groupCacheBasePath,
+		}
+	}
+
+	groupCacheSlotsOnce.Do(func() {
+		groupcache.RegisterPerGroupPeerPicker(func(name string) groupcache.PeerPicker {
+			groupCacheSlotsLock.Lock()
+			defer groupCacheSlotsLock.Unlock()
+			if slot, found := groupCacheSlots[name]; found {
+				return slot
+			}
+			return nil
+		})
+	})
+	// Use a group name unique to this replica, since group names are global per process.
+	name := "caskadht/providers@" + self
+	groupCacheSlotsLock.Lock()
+	slot, found := groupCacheSlots[name]
+	if !found {
+		slot = &groupCacheSlot{}
+		groupCacheSlots[name] = slot
+	}
+	groupCacheSlotsLock.Unlock()
+
+	slot.lock.Lock()
+	defer slot.lock.Unlock()
+	if slot.current != nil {
+		return nil, fmt.Errorf("group cache for %s is already open", self)
+	}
+	if slot.group == nil {
+		slot.group = groupcache.NewGroup(name, maxBytes, groupcache.GetterFunc(slot.get))
+	}
+	slot.current = g
+	g.slot = slot
+	g.group = slot.group
+	return g, nil
+}
+
+// close releases the group of this group cache, so that it may be reused by another group cache
+// with the same self URL.
+func (g *groupCache) close() {
+	g.slot.lock.Lock()
+	defer g.slot.lock.Unlock()
+	if g.slot.current == g {
+		g.slot.current = nil
+	}
+}
+
+// PickPeer picks the owner of the given key via the current group cache.
+func (s *groupCacheSlot) PickPeer(key string) (groupcache.ProtoGetter, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.current == nil {
+		return nil, false
+	}
+	return s.current.PickPeer(key)
+}
+
+// get looks up the providers of the given key via the current group cache.
+func (s *groupCacheSlot) get(ctx context.Context, key string, dest groupcache.Sink) error {
+	s.lock.RLock()
+	g := s.current
+	s.lock.RUnlock()
+	if g == nil {
+		return errGroupCacheClosed
+	}
+	label, mh, err := g.parseKey(key)
+	if err != nil {
+		return err
+	}
+	at := time.Now()
+	providers, err := g.lookup(ctx, label, mh)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(groupCacheValue{Providers: providers, At: at})
+	if err != nil {
+		return err
+	}
+	return dest.SetBytes(v)
+}
+
+// key returns the groupcache key for the given multihash looked up on the DHT backend with the
+// given cascade label. The key embeds the current max age window, since groupcache entries never
+// expire.
+func (g *groupCache) key(label string, mh multihash.Multihash) string {
+	window := time.Now().UnixNano() / int64(g.maxAge)
+	return mh.B58String() + "/" + strconv.FormatInt(window, 10) + "/" + label
+}
+
+// parseKey returns the cascade label and the multihash of the given groupcache key.
+func (g *groupCache) parseKey(key string) (string, multihash.Multihash, error) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return "", nil, fmt.Errorf("invalid group cache key: %s", key)
+	}
+	mh, err := multihash.FromB58String(parts[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return parts[2], mh, nil
+}
+
+func (g *groupCache) owner(key string) string {
+	b58, _, _ := strings.Cut(key, "/")
+	return g.peers.Get(b58)
+}
+
+// isLocal checks whether the given multihash is owned by this replica.
+func (g *groupCache) isLocal(mh multihash.Multihash) bool {
+	return g.peers.Get(mh.B58String()) == g.self
+}
+
+// get gets the providers of given multihash on the DHT backend with the given cascade label,
+// looking them up via the owning replica. Because entries live for the whole max age window,
+// empty results older than the negative max age are rejected with errGroupCacheNegativeExpired.
+func (g *groupCache) get(ctx context.Context, label string, mh multihash.Multihash) ([]peer.AddrInfo, error) {
+	var v []byte
+	if err := g.group.Get(ctx, g.key(label, mh), groupcache.AllocatingByteSliceSink(&v)); err != nil {
+		return nil, err
+	}
+	var value groupCacheValue
+	if err := json.Unmarshal(v, &value); err != nil {
+		return nil, err
+	}
+	if len(value.Providers) == 0 && time.Since(value.At) >= g.negativeMaxAge {
+		return nil, errGroupCacheNegativeExpired
+	}
+	return value.Providers, nil
+}
+
+// PickPeer picks the replica that owns the given key, or returns false if the key is owned by
+// this replica.
+func (g *groupCache) PickPeer(key string) (groupcache.ProtoGetter, bool) {
+	if owner := g.owner(key); owner != g.self {
+		return g.getters[owner], true
+	}
+	return nil, false
+}
+
+// ServeHTTP serves the groupcache requests of other replicas. The group name in request path
+// is ignored in favour of this replica's group, since group names are unique per replica.
+func (g *groupCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	_, escapedKey, found := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), groupCacheBasePath), "/")
+	if !found {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	key, err := url.PathUnescape(escapedKey)
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	var v []byte
+	if err := g.group.Get(r.Context(), key, groupcache.AllocatingByteSliceSink(&v)); err != nil {
+		logger.Errorw("Failed to serve group cache request", "key", key, "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	body, err := proto.Marshal(&pb.GetResponse{Value: v})
+	if err != nil {
+		logger.Errorw("Failed to encode group cache response", "key", key, "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(body)
+}
+
+func (g *groupCacheGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	u := g.baseURL + url.PathEscape(in.GetGroup()) + "/" + url.PathEscape(in.GetKey())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unsuccessful group cache response: %d", resp.StatusCode)
+	}
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, resp.Body); err != nil {
+		return err
+	}
+	return proto.Unmarshal(body.Bytes(), out)
+}
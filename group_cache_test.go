@@ -0,0 +1,140 @@
+package caskadht
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/groupcache"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_groupCache(t *testing.T) {
+	const replicaCount = 3
+	var (
+		servers     [replicaCount]*httptest.Server
+		handlers    [replicaCount]http.Handler
+		urls        []string
+		lookupCount [replicaCount]atomic.Int64
+		ids         [replicaCount]peer.ID
+	)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		urls = append(urls, servers[i].URL)
+		ids[i] = test.RandPeerIDFatal(t)
+	}
+	replicas := make([]*groupCache, replicaCount)
+	for i := range replicas {
+		i := i
+		var err error
+		replicas[i], err = newGroupCache(urls[i], urls, 1<<20, time.Hour, 0, func(_ context.Context, _ string, mh multihash.Multihash) ([]peer.AddrInfo, error) {
+			lookupCount[i].Add(1)
+			return []peer.AddrInfo{{ID: ids[i]}}, nil
+		})
+		require.NoError(t, err)
+		t.Cleanup(replicas[i].close)
+		handlers[i] = replicas[i]
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		mh, err := multihash.Sum([]byte(fmt.Sprintf("fish-%d", i)), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+
+		// Every replica must agree on the owner of the key.
+		var owner int
+		var ownerCount int
+		for j, replica := range replicas {
+			if replica.isLocal(mh) {
+				owner = j
+				ownerCount++
+			}
+		}
+		require.Equal(t, 1, ownerCount)
+
+		before := lookupCount[owner].Load()
+		for _, replica := range replicas {
+			got, err := replica.get(ctx, "fish", mh)
+			require.NoError(t, err)
+			require.Len(t, got, 1)
+			require.Equal(t, ids[owner], got[0].ID)
+		}
+		// The key must be looked up only once and by its owner.
+		require.Equal(t, before+1, lookupCount[owner].Load())
+	}
+	var total int64
+	for i := range lookupCount {
+		total += lookupCount[i].Load()
+	}
+	require.Equal(t, int64(10), total)
+}
+
+func Test_groupCacheNegativeMaxAge(t *testing.T) {
+	const self = "http://127.0.0.1:40082"
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	lookup := func(context.Context, string, multihash.Multihash) ([]peer.AddrInfo, error) {
+		return nil, nil
+	}
+	ctx := context.Background()
+
+	disabled, err := newGroupCache(self, nil, 1<<20, time.Hour, 0, lookup)
+	require.NoError(t, err)
+	_, err = disabled.get(ctx, "fish", mh)
+	require.ErrorIs(t, err, errGroupCacheNegativeExpired)
+	disabled.close()
+
+	enabled, err := newGroupCache(self, nil, 1<<20, time.Hour, time.Minute, lookup)
+	require.NoError(t, err)
+	t.Cleanup(enabled.close)
+	got, err := enabled.get(ctx, "fish", mh)
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func Test_groupCacheReusedAfterClose(t *testing.T) {
+	const self = "http://127.0.0.1:40084"
+	mh, err := multihash.Sum([]byte("lobster"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	newLookup := func(id peer.ID) groupCacheLookup {
+		return func(context.Context, string, multihash.Multihash) ([]peer.AddrInfo, error) {
+			return []peer.AddrInfo{{ID: id}}, nil
+		}
+	}
+	first := test.RandPeerIDFatal(t)
+	second := test.RandPeerIDFatal(t)
+	ctx := context.Background()
+
+	subject, err := newGroupCache(self, nil, 1<<20, time.Hour, 0, newLookup(first))
+	require.NoError(t, err)
+	// Only one group cache per self URL may be open at a time.
+	_, err = newGroupCache(self, nil, 1<<20, time.Hour, 0, newLookup(second))
+	require.Error(t, err)
+	subject.close()
+
+	// The group of a closed group cache must be reused by the next one with the same self URL.
+	reopened, err := newGroupCache(self, nil, 1<<20, time.Hour, 0, newLookup(second))
+	require.NoError(t, err)
+	require.Same(t, subject.group, reopened.group)
+	got, err := reopened.get(ctx, "lobster", mh)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, second, got[0].ID)
+
+	// Lookups of a closed group must fail rather than use a closed group cache.
+	reopened.close()
+	var v []byte
+	err = reopened.slot.get(ctx, reopened.key("crab", mh), groupcache.AllocatingByteSliceSink(&v))
+	require.ErrorIs(t, err, errGroupCacheClosed)
+}
@@ -0,0 +1,148 @@
+package caskadht
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ipfs/boxo/ipns"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+const (
+	drIpnsPathPrefix    = "/routing/v1/ipns/"
+	mediaTypeIpnsRecord = "application/vnd.ipfs.ipns-record"
+	// ipnsRecordMaxSize is the maximum size of IPNS records in bytes.
+	// See: https://specs.ipfs.tech/ipns/ipns-record/#record-size-limit
+	ipnsRecordMaxSize = 10 << 10
+)
+
+func (c *Caskadht) handleRoutingV1IpnsSubtree(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c.handleGetIpns(w, r)
+	case http.MethodPut:
+		c.handlePutIpns(w, r)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Origin", c.httpAllowOrigin)
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPut)
+		w.Header().Add("Allow", http.MethodOptions)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *Caskadht) handleGetIpns(w http.ResponseWriter, r *http.Request) {
+	name, ok := parseIpnsName(w, r)
+	if !ok {
+		return
+	}
+	if !acceptsIpnsRecord(r) {
+		http.Error(w, "media type not supported", http.StatusNotAcceptable)
+		return
+	}
+	b, _, _ := c.backendForRequest(r)
+	value, err := b.routing().GetValue(r.Context(), ipns.RecordKey(name))
+	c.metrics.notifyIpnsRequest(context.Background(), r.Method, err)
+	if err != nil {
+		if errors.Is(err, routing.ErrNotFound) {
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
+		logger.Errorw("Failed to get IPNS record", "name", name, "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaTypeIpnsRecord)
+	if _, err := w.Write(value); err != nil {
+		logger.Errorw("Failed to write IPNS record", "name", name, "err", err)
+	}
+}
+
+func (c *Caskadht) handlePutIpns(w http.ResponseWriter, r *http.Request) {
+	name, ok := parseIpnsName(w, r)
+	if !ok {
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != mediaTypeIpnsRecord {
+		http.Error(w, "content type must be "+mediaTypeIpnsRecord, http.StatusUnsupportedMediaType)
+		return
+	}
+	value, err := io.ReadAll(io.LimitReader(r.Body, ipnsRecordMaxSize+1))
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	if len(value) > ipnsRecordMaxSize {
+		http.Error(w, "record too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	key := ipns.RecordKey(name)
+	if err := c.ipnsValidator().Validate(key, value); err != nil {
+		logger.Debugw("Rejected invalid IPNS record", "name", name, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, _, _ := c.backendForRequest(r)
+	err = b.routing().PutValue(r.Context(), key, value)
+	c.metrics.notifyIpnsRequest(context.Background(), r.Method, err)
+	if err != nil {
+		logger.Errorw("Failed to put IPNS record", "name", name, "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ipnsValidator returns the validator of IPNS records, i.e. the one set for the "ipns" namespace
+// if any, or the default IPNS validator otherwise.
+// See: WithDHTValidator.
+func (c *Caskadht) ipnsValidator() record.Validator {
+	if v, ok := c.dhtValidators["ipns"]; ok {
+		return v
+	}
+	return ipns.Validator{KeyBook: c.h.Peerstore()}
+}
+
+// parseIpnsName parses the IPNS name in the request path, either as a CID with libp2p-key codec
+// or as a base58 encoded peer ID, responding with 400 Bad Request if invalid.
+func parseIpnsName(w http.ResponseWriter, r *http.Request) (peer.ID, bool) {
+	name, err := peer.Decode(strings.TrimSpace(path.Base(r.URL.Path)))
+	if err != nil || !strings.HasPrefix(r.URL.Path, drIpnsPathPrefix) {
+		http.Error(w, "invalid IPNS name", http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// acceptsIpnsRecord checks whether the request accepts IPNS record responses, where no Accept
+// header is treated as accepting any media type.
+func acceptsIpnsRecord(r *http.Request) bool {
+	accepts := r.Header.Values("Accept")
+	if len(accepts) == 0 {
+		return true
+	}
+	for _, accept := range accepts {
+		for _, amt := range strings.Split(accept, ",") {
+			mt, _, err := mime.ParseMediaType(amt)
+			if err != nil {
+				continue
+			}
+			switch mt {
+			case mediaTypeIpnsRecord, "*/*", "application/*":
+				return true
+			}
+		}
+	}
+	return false
+}
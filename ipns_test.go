@@ -0,0 +1,102 @@
+package caskadht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_handleRoutingV1IpnsSubtree(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+	subject := &Caskadht{options: &options{h: h}}
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	name, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	entry, err := ipns.Create(sk, []byte("/ipfs/bafkreifuosuzujyf4i6psbneqtwg2fhplc2wxptc5euspa2gn3bwhnihfu"), 1, time.Now().Add(time.Hour), time.Minute)
+	require.NoError(t, err)
+	value, err := proto.Marshal(entry)
+	require.NoError(t, err)
+	require.NoError(t, subject.ipnsValidator().Validate(ipns.RecordKey(name), value))
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		accept      string
+		body        []byte
+		wantStatus  int
+	}{
+		{
+			name:       "invalid name",
+			method:     http.MethodGet,
+			target:     "/routing/v1/ipns/fish",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unacceptable get",
+			method:     http.MethodGet,
+			target:     "/routing/v1/ipns/" + peer.ToCid(name).String(),
+			accept:     mediaTypeJson,
+			wantStatus: http.StatusNotAcceptable,
+		},
+		{
+			name:        "put with unsupported content type",
+			method:      http.MethodPut,
+			target:      "/routing/v1/ipns/" + peer.ToCid(name).String(),
+			contentType: mediaTypeJson,
+			body:        value,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "put with mismatching name",
+			method:      http.MethodPut,
+			target:      "/routing/v1/ipns/" + h.ID().String(),
+			contentType: mediaTypeIpnsRecord,
+			body:        value,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "put too large",
+			method:      http.MethodPut,
+			target:      "/routing/v1/ipns/" + name.String(),
+			contentType: mediaTypeIpnsRecord,
+			body:        make([]byte, ipnsRecordMaxSize+1),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "unsupported method",
+			method:     http.MethodPost,
+			target:     "/routing/v1/ipns/" + name.String(),
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			subject.handleRoutingV1IpnsSubtree(rec, r)
+			require.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package caskadht
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+type (
+	// lookupGroup coalesces concurrent lookups for the same key into a single shared lookup,
+	// the results of which are fanned out to all of its subscribers.
+	lookupGroup struct {
+		lock    sync.Mutex
+		lookups map[string]*sharedLookup
+	}
+	sharedLookup struct {
+		lock    sync.Mutex
+		found   []peer.AddrInfo
+		done    bool
+		updated chan struct{}
+		// subscribers is the number of subscribers to the lookup guarded by lookupGroup lock.
+		subscribers int
+		cancel      context.CancelFunc
+	}
+)
+
+func newLookupGroup() *lookupGroup {
+	return &lookupGroup{
+		lookups: make(map[string]*sharedLookup),
+	}
+}
+
+// join subscribes to the in-flight lookup for the given key, or starts a new one by calling
+// lookup if there is none. The returned function must be called once the subscriber no longer
+// needs the results. The shared lookup is cancelled when its last subscriber leaves.
+func (g *lookupGroup) join(ctx context.Context, key string, lookup func(context.Context) <-chan peer.AddrInfo) (*sharedLookup, func()) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	sl, found := g.lookups[key]
+	if !found {
+		lctx, cancel := context.WithCancel(ctx)
+		sl = &sharedLookup{
+			updated: make(chan struct{}),
+			cancel:  cancel,
+		}
+		g.lookups[key] = sl
+		go func() {
+			defer cancel()
+			for provider := range lookup(lctx) {
+				sl.publish(provider)
+			}
+			g.remove(key, sl)
+			sl.finish()
+		}()
+	}
+	sl.subscribers++
+	return sl, func() { g.leave(key, sl) }
+}
+
+func (g *lookupGroup) leave(key string, sl *sharedLookup) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	sl.subscribers--
+	if sl.subscribers == 0 {
+		sl.cancel()
+		if g.lookups[key] == sl {
+			delete(g.lookups, key)
+		}
+	}
+}
+
+func (g *lookupGroup) remove(key string, sl *sharedLookup) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.lookups[key] == sl {
+		delete(g.lookups, key)
+	}
+}
+
+func (s *sharedLookup) publish(provider peer.AddrInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.found = append(s.found, provider)
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+func (s *sharedLookup) finish() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.done = true
+	close(s.updated)
+}
+
+// next blocks until the provider at the given index is found and returns it. False is returned
+// if the lookup finishes before such provider is found or the given context is done.
+func (s *sharedLookup) next(ctx context.Context, i int) (peer.AddrInfo, bool) {
+	for {
+		s.lock.Lock()
+		if i < len(s.found) {
+			provider := s.found[i]
+			s.lock.Unlock()
+			return provider, true
+		}
+		if s.done {
+			s.lock.Unlock()
+			return peer.AddrInfo{}, false
+		}
+		updated := s.updated
+		s.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			return peer.AddrInfo{}, false
+		case <-updated:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package caskadht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lookupGroup(t *testing.T) {
+	var lookupCount int
+	lookupCtx := make(chan context.Context, 1)
+	providers := make(chan peer.AddrInfo)
+	lookup := func(ctx context.Context) <-chan peer.AddrInfo {
+		lookupCount++
+		lookupCtx <- ctx
+		return providers
+	}
+	subject := newLookupGroup()
+	ctx := context.Background()
+
+	first, leaveFirst := subject.join(ctx, "fish", lookup)
+	providers <- peer.AddrInfo{ID: "lobster"}
+	got, ok := first.next(ctx, 0)
+	require.True(t, ok)
+	require.Equal(t, peer.ID("lobster"), got.ID)
+
+	// Late joiners must share the in-flight lookup and see the already found providers first.
+	second, leaveSecond := subject.join(ctx, "fish", lookup)
+	require.Same(t, first, second)
+	require.Equal(t, 1, lookupCount)
+	got, ok = second.next(ctx, 0)
+	require.True(t, ok)
+	require.Equal(t, peer.ID("lobster"), got.ID)
+
+	providers <- peer.AddrInfo{ID: "crab"}
+	for _, sl := range []*sharedLookup{first, second} {
+		got, ok = sl.next(ctx, 1)
+		require.True(t, ok)
+		require.Equal(t, peer.ID("crab"), got.ID)
+	}
+
+	// The shared lookup must only be cancelled once the last subscriber leaves.
+	sctx := <-lookupCtx
+	leaveFirst()
+	require.NoError(t, sctx.Err())
+	leaveSecond()
+	require.ErrorIs(t, sctx.Err(), context.Canceled)
+
+	close(providers)
+	require.Eventually(t, func() bool {
+		_, ok := first.next(ctx, 2)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,14 +2,17 @@ package caskadht
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/pprof"
 	"runtime"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/sdk/instrumentation"
 	"go.opentelemetry.io/otel/sdk/metric/aggregation"
 
+	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/metric/instrument"
@@ -17,11 +20,29 @@ import (
 )
 
 const (
-	meterName                  = "ipni/caskadht"
-	meterLookupRespTTFP        = meterName + "/lookup_response_first_provider_time"
-	meterLookupRespResultCount = meterName + "/lookup_response_result_count"
-	meterLookupRespLatency     = meterName + "/lookup_response_latency"
-	meterLookupReqCount        = meterName + "/lookup_request_count"
+	meterName                   = "ipni/caskadht"
+	meterLookupRespTTFP         = meterName + "/lookup_response_first_provider_time"
+	meterLookupRespResultCount  = meterName + "/lookup_response_result_count"
+	meterLookupRespLatency      = meterName + "/lookup_response_latency"
+	meterLookupReqCount         = meterName + "/lookup_request_count"
+	meterLookupCacheHitCount    = meterName + "/lookup_cache_hit_count"
+	meterLookupCacheMissCount   = meterName + "/lookup_cache_miss_count"
+	meterLookupCacheNegHitCount = meterName + "/lookup_cache_negative_hit_count"
+	meterDHTClientTTFP          = meterName + "/dht_client_first_provider_time"
+	meterDHTClientProviderCount = meterName + "/dht_client_provider_count"
+	meterDHTClientSelectCount   = meterName + "/dht_client_selection_count"
+	meterUpstreamReqCount       = meterName + "/upstream_request_count"
+	meterUpstreamLatency        = meterName + "/upstream_latency"
+	meterUpstreamProviderCount  = meterName + "/upstream_provider_count"
+	meterSourceTTFP             = meterName + "/provider_source_first_provider_time"
+	meterSourceProviderCount    = meterName + "/provider_source_provider_count"
+	meterStaticHitCount         = meterName + "/static_providers_hit_count"
+	meterDenylistBlockedCount   = meterName + "/denylist_blocked_count"
+	meterPeerFilterExclCount    = meterName + "/peer_filter_excluded_count"
+	meterPeerLookupReqCount     = meterName + "/peer_lookup_request_count"
+	meterPeerLookupLatency      = meterName + "/peer_lookup_latency"
+	meterIpnsReqCount           = meterName + "/ipns_request_count"
+	meterHttpProvideCount       = meterName + "/http_provide_record_count"
 )
 
 var meterScope = instrumentation.Scope{Name: meterName}
@@ -35,6 +56,24 @@ type metrics struct {
 	lookupResponseTTFPHistogram        instrument.Int64Histogram
 	lookupResponseResultCountHistogram instrument.Int64Histogram
 	lookupResponseLatencyHistogram     instrument.Int64Histogram
+	lookupCacheHitCounter              instrument.Int64Counter
+	lookupCacheMissCounter             instrument.Int64Counter
+	lookupCacheNegativeHitCounter      instrument.Int64Counter
+	dhtClientTTFPHistogram             instrument.Int64Histogram
+	dhtClientProviderCounter           instrument.Int64Counter
+	dhtClientSelectionCounter          instrument.Int64Counter
+	upstreamRequestCounter             instrument.Int64Counter
+	upstreamLatencyHistogram           instrument.Int64Histogram
+	upstreamProviderCounter            instrument.Int64Counter
+	sourceTTFPHistogram                instrument.Int64Histogram
+	sourceProviderCounter              instrument.Int64Counter
+	staticHitCounter                   instrument.Int64Counter
+	denylistBlockedCounter             instrument.Int64Counter
+	peerFilterExcludedCounter          instrument.Int64Counter
+	peerLookupRequestCounter           instrument.Int64Counter
+	peerLookupLatencyHistogram         instrument.Int64Histogram
+	ipnsRequestCounter                 instrument.Int64Counter
+	httpProvideCounter                 instrument.Int64Counter
 }
 
 func newMetrics(c *Caskadht) (*metrics, error) {
@@ -75,6 +114,38 @@ func (m *metrics) Start(_ context.Context) error {
 					},
 				},
 			),
+			metric.NewView(
+				metric.Instrument{Name: meterDHTClientTTFP, Scope: meterScope},
+				metric.Stream{
+					Aggregation: aggregation.ExplicitBucketHistogram{
+						Boundaries: []float64{0, 50, 100, 200, 300, 400, 500, 1000, 2_000, 5_000, 10_000},
+					},
+				},
+			),
+			metric.NewView(
+				metric.Instrument{Name: meterSourceTTFP, Scope: meterScope},
+				metric.Stream{
+					Aggregation: aggregation.ExplicitBucketHistogram{
+						Boundaries: []float64{0, 50, 100, 200, 300, 400, 500, 1000, 2_000, 5_000, 10_000},
+					},
+				},
+			),
+			metric.NewView(
+				metric.Instrument{Name: meterPeerLookupLatency, Scope: meterScope},
+				metric.Stream{
+					Aggregation: aggregation.ExplicitBucketHistogram{
+						Boundaries: []float64{0, 50, 100, 200, 500, 1000, 2_000, 5_000, 10_000, 20_000, 30_000},
+					},
+				},
+			),
+			metric.NewView(
+				metric.Instrument{Name: meterUpstreamLatency, Scope: meterScope},
+				metric.Stream{
+					Aggregation: aggregation.ExplicitBucketHistogram{
+						Boundaries: []float64{0, 50, 100, 200, 500, 1000, 2_000, 5_000, 10_000, 20_000, 30_000},
+					},
+				},
+			),
 			metric.NewView(
 				metric.Instrument{Name: meterLookupRespResultCount, Scope: meterScope},
 				metric.Stream{
@@ -115,6 +186,132 @@ func (m *metrics) Start(_ context.Context) error {
 	); err != nil {
 		return err
 	}
+	if m.lookupCacheHitCounter, err = meter.Int64Counter(
+		meterLookupCacheHitCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of lookups served from the provider result cache."),
+	); err != nil {
+		return err
+	}
+	if m.lookupCacheMissCounter, err = meter.Int64Counter(
+		meterLookupCacheMissCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of lookups not found in the provider result cache."),
+	); err != nil {
+		return err
+	}
+	if m.lookupCacheNegativeHitCounter, err = meter.Int64Counter(
+		meterLookupCacheNegHitCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of lookups answered from cache with no providers."),
+	); err != nil {
+		return err
+	}
+	if m.dhtClientTTFPHistogram, err = meter.Int64Histogram(
+		meterDHTClientTTFP,
+		instrument.WithUnit("ms"),
+		instrument.WithDescription("The elapsed time for a DHT client to find its first provider in milliseconds."),
+	); err != nil {
+		return err
+	}
+	if m.dhtClientProviderCounter, err = meter.Int64Counter(
+		meterDHTClientProviderCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of providers found first by a DHT client."),
+	); err != nil {
+		return err
+	}
+	if m.dhtClientSelectionCounter, err = meter.Int64Counter(
+		meterDHTClientSelectCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of lookups routed to a DHT client, by selection reason."),
+	); err != nil {
+		return err
+	}
+	if m.upstreamRequestCounter, err = meter.Int64Counter(
+		meterUpstreamReqCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of requests made to an upstream delegated routing endpoint, by result."),
+	); err != nil {
+		return err
+	}
+	if m.upstreamLatencyHistogram, err = meter.Int64Histogram(
+		meterUpstreamLatency,
+		instrument.WithUnit("ms"),
+		instrument.WithDescription("The upstream delegated routing response latency."),
+	); err != nil {
+		return err
+	}
+	if m.upstreamProviderCounter, err = meter.Int64Counter(
+		meterUpstreamProviderCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of providers found by an upstream delegated routing endpoint."),
+	); err != nil {
+		return err
+	}
+	if m.sourceTTFPHistogram, err = meter.Int64Histogram(
+		meterSourceTTFP,
+		instrument.WithUnit("ms"),
+		instrument.WithDescription("The elapsed time for a provider source to find its first provider in milliseconds."),
+	); err != nil {
+		return err
+	}
+	if m.sourceProviderCounter, err = meter.Int64Counter(
+		meterSourceProviderCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of providers found by a provider source."),
+	); err != nil {
+		return err
+	}
+	if m.staticHitCounter, err = meter.Int64Counter(
+		meterStaticHitCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of lookups that matched static providers."),
+	); err != nil {
+		return err
+	}
+	if m.denylistBlockedCounter, err = meter.Int64Counter(
+		meterDenylistBlockedCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of lookups rejected because their key is denylisted."),
+	); err != nil {
+		return err
+	}
+	if m.peerFilterExcludedCounter, err = meter.Int64Counter(
+		meterPeerFilterExclCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of providers excluded from results by the peer filter."),
+	); err != nil {
+		return err
+	}
+	if m.peerLookupRequestCounter, err = meter.Int64Counter(
+		meterPeerLookupReqCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of peer lookup requests."),
+	); err != nil {
+		return err
+	}
+	if m.peerLookupLatencyHistogram, err = meter.Int64Histogram(
+		meterPeerLookupLatency,
+		instrument.WithUnit("ms"),
+		instrument.WithDescription("The peer lookup latency."),
+	); err != nil {
+		return err
+	}
+	if m.ipnsRequestCounter, err = meter.Int64Counter(
+		meterIpnsReqCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of IPNS record get and put requests."),
+	); err != nil {
+		return err
+	}
+	if m.httpProvideCounter, err = meter.Int64Counter(
+		meterHttpProvideCount,
+		instrument.WithUnit("1"),
+		instrument.WithDescription("The number of provider records received via the delegated routing HTTP API."),
+	); err != nil {
+		return err
+	}
 
 	m.server.Handler = m.serveMux()
 	go func() { _ = m.server.ListenAndServe() }()
@@ -158,6 +355,97 @@ func (m *metrics) notifyLookupResponded(ctx context.Context, resultCount int64,
 	m.lookupResponseLatencyHistogram.Record(ctx, latency.Milliseconds())
 }
 
+func (m *metrics) notifyLookupCacheHit(ctx context.Context) {
+	m.lookupCacheHitCounter.Add(ctx, 1)
+}
+
+func (m *metrics) notifyLookupCacheMiss(ctx context.Context) {
+	m.lookupCacheMissCounter.Add(ctx, 1)
+}
+
+func (m *metrics) notifyLookupCacheNegativeHit(ctx context.Context) {
+	m.lookupCacheNegativeHitCounter.Add(ctx, 1)
+}
+
+func (m *metrics) notifyDHTClientFirstProvider(ctx context.Context, client string, timeToFirstProvider time.Duration) {
+	m.dhtClientTTFPHistogram.Record(ctx, timeToFirstProvider.Milliseconds(), attribute.String("client", client))
+}
+
+func (m *metrics) notifyDHTClientProviderFound(ctx context.Context, client string) {
+	m.dhtClientProviderCounter.Add(ctx, 1, attribute.String("client", client))
+}
+
+func (m *metrics) notifyDHTClientSelected(ctx context.Context, client, reason string) {
+	m.dhtClientSelectionCounter.Add(ctx, 1, attribute.String("client", client), attribute.String("reason", reason))
+}
+
+func (m *metrics) notifyUpstreamResponded(ctx context.Context, upstream string, providerCount int64, latency time.Duration, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	upstreamAttr := attribute.String("upstream", upstream)
+	m.upstreamRequestCounter.Add(ctx, 1, upstreamAttr, attribute.String("result", result))
+	m.upstreamLatencyHistogram.Record(ctx, latency.Milliseconds(), upstreamAttr)
+	m.upstreamProviderCounter.Add(ctx, providerCount, upstreamAttr)
+}
+
+func (m *metrics) notifyProviderSourceFirstProvider(ctx context.Context, source string, timeToFirstProvider time.Duration) {
+	m.sourceTTFPHistogram.Record(ctx, timeToFirstProvider.Milliseconds(), attribute.String("source", source))
+}
+
+func (m *metrics) notifyProviderSourceProviderFound(ctx context.Context, source string) {
+	m.sourceProviderCounter.Add(ctx, 1, attribute.String("source", source))
+}
+
+func (m *metrics) notifyStaticProvidersHit(ctx context.Context) {
+	m.staticHitCounter.Add(ctx, 1)
+}
+
+func (m *metrics) notifyDenylistBlocked(ctx context.Context, api string) {
+	m.denylistBlockedCounter.Add(ctx, 1, attribute.String("api", api))
+}
+
+func (m *metrics) notifyPeerFilterExcluded(ctx context.Context, mode string) {
+	m.peerFilterExcludedCounter.Add(ctx, 1, attribute.String("mode", mode))
+}
+
+func (m *metrics) notifyPeerLookupResponded(ctx context.Context, err error, latency time.Duration) {
+	result := "found"
+	switch {
+	case errors.Is(err, routing.ErrNotFound):
+		result = "not_found"
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	m.peerLookupRequestCounter.Add(ctx, 1, attribute.String("result", result))
+	m.peerLookupLatencyHistogram.Record(ctx, latency.Milliseconds())
+}
+
+func (m *metrics) notifyIpnsRequest(ctx context.Context, method string, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, routing.ErrNotFound):
+		result = "not_found"
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	m.ipnsRequestCounter.Add(ctx, 1, attribute.String("method", method), attribute.String("result", result))
+}
+
+// notifyHttpProvide records the outcome of providing a record received via the delegated routing
+// HTTP API: "accepted" if all its keys were provided, "partial" if only some were, or "rejected".
+func (m *metrics) notifyHttpProvide(ctx context.Context, outcome string) {
+	m.httpProvideCounter.Add(ctx, 1, attribute.String("result", outcome))
+}
+
 func (m *metrics) Shutdown(ctx context.Context) error {
 	return m.server.Shutdown(ctx)
 }
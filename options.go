@@ -1,12 +1,18 @@
 package caskadht
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"time"
 
+	"github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
 type (
@@ -19,32 +25,110 @@ type (
 		metricsHttpListenAddr        string
 		metricsEnablePprofDebug      bool
 		bootstrapPeers               []peer.AddrInfo
+		dhtProtocolPrefix            protocol.ID
+		dhtMode                      dht.ModeOpt
+		dhtBucketSize                int
+		dhtConcurrency               int
+		dhtResiliency                int
+		dhtRTRefreshPeriod           time.Duration
+		dhtValidators                map[string]record.Validator
 		useAccDHT                    bool
+		accDHTCrawlInterval          time.Duration
+		accDHTCrawlerParallelism     int
+		accDHTCrawlerConnectTimeout  time.Duration
+		accDHTCrawlerMsgTimeout      time.Duration
+		accDHTBulkSendParallelism    int
+		accDHTTimeoutPerOperation    time.Duration
+		dhtClientSelection           DHTClientSelection
+		dhtClientAdaptiveWindow      int
+		dhtClientAdaptiveExploration float64
 		ipniCascadeLabel             string
 		ipniRequireCascadeQueryParam bool
+		addrFilter                   AddrFilter
 		addrFilterDisabled           bool
 		findProvidersLimit           int
 		prAttemptCacheMaxSize        int
 		prAttemptCacheMaxAge         time.Duration
+		resultCacheMaxSize           int
+		resultCacheMaxAge            time.Duration
+		resultCacheNegativeMaxAge    time.Duration
+		resultCacheStaleWhileReval   time.Duration
+		resultCacheMaxRevalidations  int
+		ds                           datastore.Datastore
+		groupCacheSelf               string
+		groupCachePeers              []string
+		groupCacheMaxBytes           int64
+		groupCacheListenAddr         string
+		dhtBackends                  []dhtBackendConfig
+		drUpstreams                  []*drUpstream
+		ipniUpstreams                []*ipniUpstream
+		customSources                []ProviderSource
+		dhtProviderSourceDisabled    bool
+		staticProvidersPath          string
+		staticProvidersCheckInterval time.Duration
+		denylistPaths                []string
+		denylistCheckInterval        time.Duration
+		peerFilterPath               string
+		peerFilterAllowlist          bool
+		peerFilterCheckInterval      time.Duration
+		adminHttpListenAddr          string
+		drSchema                     DelegatedRoutingSchema
+		httpProvideEnabled           bool
+		httpProvideMaxTTL            time.Duration
+		httpProvideMaxPerPeer        int
+		httpProvideMaxRecords        int
+	}
+	// dhtBackendConfig is the configuration of an additional DHT backend.
+	// See: WithDHTBackend.
+	dhtBackendConfig struct {
+		label          string
+		protocolPrefix protocol.ID
+		bootstrapPeers []peer.AddrInfo
+		useAccDHT      bool
 	}
 )
 
 func newOptions(o ...Option) (*options, error) {
 	opts := options{
-		httpListenAddr:          "0.0.0.0:40080",
-		metricsHttpListenAddr:   "0.0.0.0:40081",
-		metricsEnablePprofDebug: true,
-		useAccDHT:               false,
-		ipniCascadeLabel:        "ipfs-dht",
-		httpAllowOrigin:         "*",
-		prAttemptCacheMaxSize:   1024,
-		prAttemptCacheMaxAge:    20 * time.Minute,
+		httpListenAddr:               "0.0.0.0:40080",
+		metricsHttpListenAddr:        "0.0.0.0:40081",
+		metricsEnablePprofDebug:      true,
+		dhtProtocolPrefix:            ipfsProtocolPrefix,
+		dhtMode:                      dht.ModeClient,
+		useAccDHT:                    false,
+		accDHTCrawlerParallelism:     200,
+		dhtClientSelection:           DHTClientSelectionReady,
+		dhtClientAdaptiveWindow:      100,
+		dhtClientAdaptiveExploration: 0.05,
+		ipniCascadeLabel:             "ipfs-dht",
+		httpAllowOrigin:              "*",
+		addrFilter:                   PubliclyDialableAddrFilter,
+		drSchema:                     DelegatedRoutingSchemaBitswap,
+		prAttemptCacheMaxSize:        1024,
+		prAttemptCacheMaxAge:         20 * time.Minute,
+		resultCacheMaxAge:            5 * time.Minute,
+		resultCacheMaxRevalidations:  16,
+		groupCacheMaxBytes:           64 << 20,
+		groupCacheListenAddr:         "0.0.0.0:40082",
+		staticProvidersCheckInterval: 10 * time.Second,
+		denylistCheckInterval:        10 * time.Second,
+		peerFilterCheckInterval:      10 * time.Second,
+		adminHttpListenAddr:          "127.0.0.1:40083",
+		httpProvideMaxTTL:            48 * time.Hour,
+		httpProvideMaxPerPeer:        1024,
+		httpProvideMaxRecords:        100_000,
 	}
 	for _, apply := range o {
 		if err := apply(&opts); err != nil {
 			return nil, err
 		}
 	}
+	if opts.addrFilterDisabled {
+		opts.addrFilter = nil
+	}
+	if opts.ds != nil && opts.resultCacheMaxSize <= 0 {
+		return nil, errors.New("datastore requires the provider result cache to be enabled")
+	}
 
 	var err error
 	if opts.h == nil {
@@ -53,19 +137,48 @@ func newOptions(o ...Option) (*options, error) {
 			return nil, err
 		}
 	}
-	if len(opts.bootstrapPeers) == 0 {
-		opts.bootstrapPeers = make([]peer.AddrInfo, 0, len(dht.DefaultBootstrapPeers))
-		for _, p := range dht.DefaultBootstrapPeers {
-			pa, err := peer.AddrInfoFromP2pAddr(p)
-			if err != nil {
-				return nil, err
-			}
-			opts.bootstrapPeers = append(opts.bootstrapPeers, *pa)
+	if opts.bootstrapPeers, err = bootstrapPeersOrDefault(opts.dhtProtocolPrefix, opts.bootstrapPeers); err != nil {
+		return nil, err
+	}
+	if opts.dhtProviderSourceDisabled && len(opts.drUpstreams) == 0 && len(opts.customSources) == 0 {
+		return nil, errors.New("at least one provider source must be used when the DHT provider source is disabled")
+	}
+	labels := map[string]struct{}{opts.ipniCascadeLabel: {}}
+	for i := range opts.dhtBackends {
+		b := &opts.dhtBackends[i]
+		if _, exists := labels[b.label]; exists {
+			return nil, fmt.Errorf("duplicate DHT backend cascade label: %s", b.label)
+		}
+		labels[b.label] = struct{}{}
+		if b.bootstrapPeers, err = bootstrapPeersOrDefault(b.protocolPrefix, b.bootstrapPeers); err != nil {
+			return nil, fmt.Errorf("DHT backend %s: %w", b.label, err)
 		}
 	}
 	return &opts, nil
 }
 
+// bootstrapPeersOrDefault returns the given bootstrap peers, or the default IPFS DHT bootstrap
+// peers if none are given and the protocol prefix is that of the IPFS DHT.
+func bootstrapPeersOrDefault(prefix protocol.ID, peers []peer.AddrInfo) ([]peer.AddrInfo, error) {
+	if len(peers) != 0 {
+		return peers, nil
+	}
+	if prefix != ipfsProtocolPrefix {
+		// The default bootstrap peers are members of the IPFS DHT, and are of no use to DHTs
+		// with any other protocol prefix.
+		return nil, errors.New("bootstrap peers must be specified when using a custom DHT protocol prefix")
+	}
+	peers = make([]peer.AddrInfo, 0, len(dht.DefaultBootstrapPeers))
+	for _, p := range dht.DefaultBootstrapPeers {
+		pa, err := peer.AddrInfoFromP2pAddr(p)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, *pa)
+	}
+	return peers, nil
+}
+
 func WithHost(h host.Host) Option {
 	return func(o *options) error {
 		o.h = h
@@ -80,6 +193,9 @@ func WithHttpListenAddr(a string) Option {
 	}
 }
 
+// WithBootstrapPeers sets the peers used to bootstrap the DHT clients. Defaults to
+// dht.DefaultBootstrapPeers, and must be set when a custom DHT protocol prefix is used.
+// See: WithDHTProtocolPrefix.
 func WithBootstrapPeers(p ...peer.AddrInfo) Option {
 	return func(o *options) error {
 		o.bootstrapPeers = p
@@ -87,6 +203,62 @@ func WithBootstrapPeers(p ...peer.AddrInfo) Option {
 	}
 }
 
+// WithDHTProtocolPrefix sets the protocol prefix of the DHT onto which lookups are cascaded.
+// Note that the IPFS DHT, i.e. the "/ipfs" prefix, mandates the default DHT parameters such as
+// bucket size. When set to any other prefix, such as that of a private DHT, the bootstrap peers
+// must also be set. Defaults to "/ipfs".
+// See: WithBootstrapPeers.
+func WithDHTProtocolPrefix(p protocol.ID) Option {
+	return func(o *options) error {
+		o.dhtProtocolPrefix = p
+		return nil
+	}
+}
+
+// WithDHTMode sets the mode in which the standard DHT client operates. Defaults to dht.ModeClient.
+func WithDHTMode(m dht.ModeOpt) Option {
+	return func(o *options) error {
+		o.dhtMode = m
+		return nil
+	}
+}
+
+// WithDHTBucketSize sets the bucket size of the standard DHT client routing table. Defaults to
+// zero, i.e. the DHT default.
+func WithDHTBucketSize(s int) Option {
+	return func(o *options) error {
+		o.dhtBucketSize = s
+		return nil
+	}
+}
+
+// WithDHTConcurrency sets the number of concurrent queries per lookup, i.e. alpha, of the standard
+// DHT client. Defaults to zero, i.e. the DHT default.
+func WithDHTConcurrency(alpha int) Option {
+	return func(o *options) error {
+		o.dhtConcurrency = alpha
+		return nil
+	}
+}
+
+// WithDHTResiliency sets the number of closest peers that must respond for a lookup to complete,
+// i.e. beta, of the standard DHT client. Defaults to zero, i.e. the DHT default.
+func WithDHTResiliency(beta int) Option {
+	return func(o *options) error {
+		o.dhtResiliency = beta
+		return nil
+	}
+}
+
+// WithDHTRoutingTableRefreshPeriod sets the interval at which the standard DHT client refreshes
+// its routing table. Defaults to zero, i.e. the DHT default.
+func WithDHTRoutingTableRefreshPeriod(d time.Duration) Option {
+	return func(o *options) error {
+		o.dhtRTRefreshPeriod = d
+		return nil
+	}
+}
+
 func WithUseAcceleratedDHT(b bool) Option {
 	return func(o *options) error {
 		o.useAccDHT = b
@@ -94,6 +266,114 @@ func WithUseAcceleratedDHT(b bool) Option {
 	}
 }
 
+// WithDHTClientSelection sets how the DHT client used for provider lookups is selected when the
+// accelerated DHT client is enabled. Defaults to DHTClientSelectionReady.
+// See: WithUseAcceleratedDHT.
+func WithDHTClientSelection(s DHTClientSelection) Option {
+	return func(o *options) error {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		o.dhtClientSelection = s
+		return nil
+	}
+}
+
+// WithDHTClientAdaptiveWindow sets the number of most recent lookups per DHT client over which
+// their performance is compared when using DHTClientSelectionAdaptive. Defaults to 100.
+func WithDHTClientAdaptiveWindow(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			return errors.New("adaptive window must be at least 1")
+		}
+		o.dhtClientAdaptiveWindow = n
+		return nil
+	}
+}
+
+// WithDHTClientAdaptiveExploration sets the fraction of lookups sent to the worse performing DHT
+// client when using DHTClientSelectionAdaptive. Must be within [0, 1]. Defaults to 0.05.
+func WithDHTClientAdaptiveExploration(f float64) Option {
+	return func(o *options) error {
+		if f < 0 || f > 1 {
+			return errors.New("adaptive exploration must be within [0, 1]")
+		}
+		o.dhtClientAdaptiveExploration = f
+		return nil
+	}
+}
+
+// WithDHTValidator sets the record validator for the given namespace, used by both the standard
+// and the accelerated DHT clients. By default, only "pk" and "ipns" namespaces are validated.
+// Note that the IPFS DHT, i.e. the "/ipfs" prefix, mandates the default validators.
+func WithDHTValidator(ns string, v record.Validator) Option {
+	return func(o *options) error {
+		if o.dhtValidators == nil {
+			o.dhtValidators = make(map[string]record.Validator)
+		}
+		o.dhtValidators[ns] = v
+		return nil
+	}
+}
+
+// WithAccDHTCrawlInterval sets the interval at which the accelerated DHT client crawls the DHT in
+// order to refresh its routing table. Defaults to zero, i.e. the accelerated DHT client default.
+func WithAccDHTCrawlInterval(d time.Duration) Option {
+	return func(o *options) error {
+		o.accDHTCrawlInterval = d
+		return nil
+	}
+}
+
+// WithAccDHTCrawlerParallelism sets the number of concurrent queries made by the accelerated DHT
+// client while crawling the DHT. Defaults to 200.
+func WithAccDHTCrawlerParallelism(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			return errors.New("crawler parallelism must be at least 1")
+		}
+		o.accDHTCrawlerParallelism = n
+		return nil
+	}
+}
+
+// WithAccDHTCrawlerConnectTimeout sets the timeout of connecting to a peer while crawling the DHT
+// by the accelerated DHT client. Defaults to zero, i.e. the crawler default.
+func WithAccDHTCrawlerConnectTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		o.accDHTCrawlerConnectTimeout = d
+		return nil
+	}
+}
+
+// WithAccDHTCrawlerMsgTimeout sets the timeout of a single DHT message while crawling the DHT by
+// the accelerated DHT client. Defaults to zero, i.e. the crawler default.
+func WithAccDHTCrawlerMsgTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		o.accDHTCrawlerMsgTimeout = d
+		return nil
+	}
+}
+
+// WithAccDHTBulkSendParallelism sets the maximum number of concurrent messages sent by the
+// accelerated DHT client for bulk operations. Defaults to zero, i.e. the accelerated DHT client
+// default.
+func WithAccDHTBulkSendParallelism(n int) Option {
+	return func(o *options) error {
+		o.accDHTBulkSendParallelism = n
+		return nil
+	}
+}
+
+// WithAccDHTTimeoutPerOperation sets the timeout of each operation, such as querying the DHT, made
+// by the accelerated DHT client. Defaults to zero, i.e. the accelerated DHT client default.
+func WithAccDHTTimeoutPerOperation(d time.Duration) Option {
+	return func(o *options) error {
+		o.accDHTTimeoutPerOperation = d
+		return nil
+	}
+}
+
 func WithIpniCascadeLabel(l string) Option {
 	return func(o *options) error {
 		o.ipniCascadeLabel = l
@@ -128,9 +408,27 @@ func WithHttpResponsePreferJson(b bool) Option {
 	}
 }
 
-// WithAddrFilterDisabled sets whether to filter out addresses that are not publicly dialable.
-// By default such address are excluded from results.
-// See: IsPubliclyDialableAddr.
+// WithDelegatedRoutingSchema sets the default schema of the records in delegated routing lookup
+// responses. Clients may override it per request via the "schema" query parameter, e.g.
+// "?schema=peer" for clients that understand the peer schema. Note that the query parameter is a
+// caskadht-only extension which standard delegated routing clients do not send, so they always get
+// the schema set here.
+// Defaults to DelegatedRoutingSchemaBitswap, which existing clients understand.
+func WithDelegatedRoutingSchema(s DelegatedRoutingSchema) Option {
+	return func(o *options) error {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		o.drSchema = s
+		return nil
+	}
+}
+
+// WithAddrFilterDisabled sets whether to disable the filtering of addresses, which by default
+// excludes addresses that are not publicly dialable from results. Disabling takes precedence over
+// any filter set via WithAddrFilter, regardless of the order of options. Defaults to false, i.e.
+// the filter set via WithAddrFilter is used.
+// See: IsPubliclyDialableAddr, WithAddrFilter.
 func WithAddrFilterDisabled(b bool) Option {
 	return func(o *options) error {
 		o.addrFilterDisabled = b
@@ -138,6 +436,19 @@ func WithAddrFilterDisabled(b bool) Option {
 	}
 }
 
+// WithAddrFilter sets the filter that decides which provider addresses are included in results.
+// Providers with no addresses left after filtering are excluded from results. Filters may be
+// composed using AllAddrFilters and AnyAddrFilter, and a nil filter disables filtering. Ignored
+// if filtering is disabled via WithAddrFilterDisabled.
+// Defaults to PubliclyDialableAddrFilter.
+// See: NewCIDRAddrFilter, NewTransportAddrFilter, NewRelayAddrFilter, NewDNSSuffixAddrFilter.
+func WithAddrFilter(f AddrFilter) Option {
+	return func(o *options) error {
+		o.addrFilter = f
+		return nil
+	}
+}
+
 // WithFindProvidersLimit sets the limit on number of providers to find.
 // Defaults to zero, i.e. no limit.
 func WithFindProvidersLimit(l int) Option {
@@ -147,6 +458,350 @@ func WithFindProvidersLimit(l int) Option {
 	}
 }
 
+// WithResultCacheMaxSize sets the maximum number of multihashes for which the found providers
+// are cached. A non-positive value disables the provider result cache.
+// Defaults to zero, i.e. the provider result cache is disabled.
+func WithResultCacheMaxSize(s int) Option {
+	return func(o *options) error {
+		o.resultCacheMaxSize = s
+		return nil
+	}
+}
+
+// WithResultCacheMaxAge sets the maximum duration for which the found providers are cached.
+// Defaults to 5 minutes.
+func WithResultCacheMaxAge(d time.Duration) Option {
+	return func(o *options) error {
+		o.resultCacheMaxAge = d
+		return nil
+	}
+}
+
+// WithResultCacheNegativeMaxAge sets the maximum duration for which lookups that found no
+// providers are cached. A non-positive value disables caching of such lookups.
+// Defaults to zero, i.e. such lookups are not cached.
+func WithResultCacheNegativeMaxAge(d time.Duration) Option {
+	return func(o *options) error {
+		o.resultCacheNegativeMaxAge = d
+		return nil
+	}
+}
+
+// WithResultCacheStaleWhileRevalidate sets the duration past the max age during which a cached
+// result is still served while it is refreshed in the background. Defaults to zero, i.e. expired
+// results are never served.
+// See: WithResultCacheMaxRevalidations.
+func WithResultCacheStaleWhileRevalidate(d time.Duration) Option {
+	return func(o *options) error {
+		o.resultCacheStaleWhileReval = d
+		return nil
+	}
+}
+
+// WithResultCacheMaxRevalidations sets the maximum number of concurrent background lookups that
+// refresh stale cached results. Stale results that cannot be refreshed due to this limit are
+// served until they fully expire. Defaults to 16.
+func WithResultCacheMaxRevalidations(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			return errors.New("max revalidations must be at least 1")
+		}
+		o.resultCacheMaxRevalidations = n
+		return nil
+	}
+}
+
+// WithDatastore sets the datastore in which the provider result cache and the addrs of peers
+// discovered during lookups are persisted. The persisted entries are reloaded on Start, and
+// expired entries are removed as they are read. Requires the provider result cache to be
+// enabled, since provider results are only persisted as they are cached. By default, nothing is
+// persisted.
+// See: WithResultCacheMaxSize.
+func WithDatastore(ds datastore.Datastore) Option {
+	return func(o *options) error {
+		o.ds = ds
+		return nil
+	}
+}
+
+// WithGroupCache enables sharing of provider lookup results across caskadht replicas via
+// groupcache. The self and peers are the base URLs of group cache servers of this replica and the
+// other replicas respectively, e.g. "http://10.0.0.1:40082". Each key is looked up by a single
+// owner replica, and shared results are refreshed every result cache max age. Empty results are
+// shared for at most the result cache negative max age. Within a process, only one instance may
+// use the same self URL at a time, and it releases it on Shutdown.
+// Disabled by default.
+// See: WithGroupCacheListenAddr, WithResultCacheMaxAge, WithResultCacheNegativeMaxAge.
+func WithGroupCache(self string, peers ...string) Option {
+	return func(o *options) error {
+		o.groupCacheSelf = self
+		o.groupCachePeers = peers
+		return nil
+	}
+}
+
+// WithGroupCacheMaxBytes sets the maximum size of the group cache in bytes.
+// Defaults to 64 MiB.
+func WithGroupCacheMaxBytes(b int64) Option {
+	return func(o *options) error {
+		o.groupCacheMaxBytes = b
+		return nil
+	}
+}
+
+// WithGroupCacheListenAddr sets the address on which group cache requests of other replicas are
+// served. The group cache server is unauthenticated, and should only be reachable by replicas.
+// Defaults to "0.0.0.0:40082".
+// See: WithGroupCache.
+func WithGroupCacheListenAddr(a string) Option {
+	return func(o *options) error {
+		o.groupCacheListenAddr = a
+		return nil
+	}
+}
+
+// WithDHTBackend adds a DHT backend onto which lookups are cascaded, in addition to the one
+// configured via WithDHTProtocolPrefix, WithBootstrapPeers, WithUseAcceleratedDHT and labeled by
+// WithIpniCascadeLabel. Lookup requests are routed to the backend whose label matches the IPNI
+// "cascade" query parameter, and to the latter backend if there is no match.
+//
+// The bootstrap peers default to the IPFS DHT bootstrap peers, and must be specified if the
+// protocol prefix is not "/ipfs". All other DHT parameters are shared across backends.
+// May be specified multiple times; labels must be unique.
+func WithDHTBackend(label string, prefix protocol.ID, useAccDHT bool, bootstrapPeers ...peer.AddrInfo) Option {
+	return func(o *options) error {
+		if label == "" {
+			return errors.New("DHT backend cascade label must not be empty")
+		}
+		o.dhtBackends = append(o.dhtBackends, dhtBackendConfig{
+			label:          label,
+			protocolPrefix: prefix,
+			bootstrapPeers: bootstrapPeers,
+			useAccDHT:      useAccDHT,
+		})
+		return nil
+	}
+}
+
+// WithDelegatedRoutingUpstream adds an upstream delegated routing HTTP endpoint to which lookups
+// are fanned out in addition to the DHT, e.g. "https://delegated-ipfs.dev". The providers found
+// by upstreams are merged with the ones found on the DHT, deduplicated by peer ID. Each request
+// to the upstream is bounded by the given timeout, where zero means no timeout.
+// May be specified multiple times. No upstreams are used by default.
+func WithDelegatedRoutingUpstream(u string, timeout time.Duration) Option {
+	return func(o *options) error {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("unsupported delegated routing upstream scheme: %s", parsed.Scheme)
+		}
+		if timeout < 0 {
+			return errors.New("delegated routing upstream timeout must not be negative")
+		}
+		o.drUpstreams = append(o.drUpstreams, newDrUpstream(u, timeout))
+		return nil
+	}
+}
+
+// WithProviderSources adds custom sources of providers onto which lookups are cascaded alongside
+// the DHT. May be specified multiple times. No custom sources are used by default.
+// See: WithDHTProviderSourceDisabled.
+func WithProviderSources(s ...ProviderSource) Option {
+	return func(o *options) error {
+		o.customSources = append(o.customSources, s...)
+		return nil
+	}
+}
+
+// WithDHTProviderSourceDisabled sets whether to not find providers on the DHT, in which case
+// lookups are cascaded only onto the delegated routing upstreams and the custom provider sources.
+// Note that the DHT is still used to discover the addrs of providers that have none.
+// Defaults to false.
+// See: WithProviderSources, WithDelegatedRoutingUpstream.
+func WithDHTProviderSourceDisabled(d bool) Option {
+	return func(o *options) error {
+		o.dhtProviderSourceDisabled = d
+		return nil
+	}
+}
+
+// WithStaticProvidersPath sets the path to the JSON or YAML file that maps multihashes, CIDs or
+// prefixes thereof suffixed with "*" to fixed providers. Static providers are served ahead of
+// the providers found by lookups, and the file is reloaded whenever it changes.
+// Disabled by default.
+// See: WithStaticProvidersCheckInterval, Caskadht.ReloadStaticProviders.
+func WithStaticProvidersPath(p string) Option {
+	return func(o *options) error {
+		o.staticProvidersPath = p
+		return nil
+	}
+}
+
+// WithStaticProvidersCheckInterval sets the interval at which the static providers file is checked
+// for changes. Defaults to 10 seconds.
+func WithStaticProvidersCheckInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("static providers check interval must be positive")
+		}
+		o.staticProvidersCheckInterval = d
+		return nil
+	}
+}
+
+// WithDenylistPaths adds the paths to denylist files in the compact denylist format specified
+// by IPIP-383, or the legacy double-hashed badbits format. Lookups for any content blocked by the
+// denylists are rejected with status 410 Gone, and the files are reloaded whenever they change.
+// Disabled by default.
+// See: WithDenylistCheckInterval, Caskadht.ReloadDenylist, https://github.com/ipfs/specs/pull/383.
+func WithDenylistPaths(p ...string) Option {
+	return func(o *options) error {
+		o.denylistPaths = append(o.denylistPaths, p...)
+		return nil
+	}
+}
+
+// WithDenylistCheckInterval sets the interval at which the denylist files are checked for changes.
+// Defaults to 10 seconds.
+func WithDenylistCheckInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("denylist check interval must be positive")
+		}
+		o.denylistCheckInterval = d
+		return nil
+	}
+}
+
+// WithPeerFilterPath sets the path to the file listing the peer IDs of providers to exclude from
+// lookup results, one per line. The file is reloaded whenever it changes, and rewritten when the
+// list is updated via the admin endpoint at /admin/peer-filter on the admin server.
+// Disabled by default.
+// See: WithPeerFilterAllowlist, WithPeerFilterCheckInterval, WithAdminListenAddr,
+// Caskadht.ReloadPeerFilter.
+func WithPeerFilterPath(p string) Option {
+	return func(o *options) error {
+		o.peerFilterPath = p
+		return nil
+	}
+}
+
+// WithPeerFilterAllowlist sets whether the peer filter is in allowlist mode, where only the
+// providers listed by the peer filter are included in lookup results. If no peer filter path is
+// set, the list starts empty and is only updatable via the admin endpoint.
+// Defaults to false, i.e. the listed providers are excluded.
+// See: WithPeerFilterPath.
+func WithPeerFilterAllowlist(b bool) Option {
+	return func(o *options) error {
+		o.peerFilterAllowlist = b
+		return nil
+	}
+}
+
+// WithPeerFilterCheckInterval sets the interval at which the peer filter file is checked for
+// changes. Defaults to 10 seconds.
+// See: WithPeerFilterPath.
+func WithPeerFilterCheckInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("peer filter check interval must be positive")
+		}
+		o.peerFilterCheckInterval = d
+		return nil
+	}
+}
+
+// WithAdminListenAddr sets the address on which the admin server is listening, which serves the
+// peer filter admin endpoint if the peer filter is enabled. The admin server is unauthenticated,
+// and should only be reachable by operators.
+// Defaults to "127.0.0.1:40083".
+// See: WithPeerFilterPath, WithPeerFilterAllowlist.
+func WithAdminListenAddr(a string) Option {
+	return func(o *options) error {
+		o.adminHttpListenAddr = a
+		return nil
+	}
+}
+
+// WithHttpProvideEnabled sets whether to accept signed provider records via PUT requests to the
+// delegated routing HTTP API at /routing/v1/providers. Accepted records are persisted and served
+// in the lookup responses of this instance only; they are not announced to the DHT, since DHT
+// peers only accept provider records from the providing peer itself. Disabled by default, in
+// which case such requests are rejected with status 501 Not Implemented.
+// See: WithHttpProvideMaxTTL, WithHttpProvideMaxPerPeer, WithHttpProvideMaxRecords.
+func WithHttpProvideEnabled(b bool) Option {
+	return func(o *options) error {
+		o.httpProvideEnabled = b
+		return nil
+	}
+}
+
+// WithHttpProvideMaxTTL sets the maximum duration for which provider records accepted via the
+// delegated routing HTTP API are kept, counted from their timestamp. Records that advertise a
+// longer TTL, or none, are kept for this duration. Defaults to 48 hours, i.e. the validity of
+// provider records in the DHT.
+func WithHttpProvideMaxTTL(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("HTTP provide max TTL must be positive")
+		}
+		o.httpProvideMaxTTL = d
+		return nil
+	}
+}
+
+// WithHttpProvideMaxPerPeer sets the maximum number of unexpired provides, i.e. pairs of key and
+// provider, kept per provider from records accepted via the delegated routing HTTP API. Keys that
+// would exceed the limit are rejected. Defaults to 1024.
+// See: WithHttpProvideEnabled.
+func WithHttpProvideMaxPerPeer(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			return errors.New("HTTP provide max per peer must be at least 1")
+		}
+		o.httpProvideMaxPerPeer = n
+		return nil
+	}
+}
+
+// WithHttpProvideMaxRecords sets the maximum number of unexpired provides, i.e. pairs of key and
+// provider, kept in total from records accepted via the delegated routing HTTP API. Keys that
+// would exceed the limit are rejected. Defaults to 100,000.
+// See: WithHttpProvideEnabled.
+func WithHttpProvideMaxRecords(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			return errors.New("HTTP provide max records must be at least 1")
+		}
+		o.httpProvideMaxRecords = n
+		return nil
+	}
+}
+
+// WithIpniUpstream adds an upstream IPNI indexer to which lookups are fanned out in addition to the
+// DHT, e.g. "https://cid.contact". The provider results found by upstreams are merged into IPNI
+// responses with their original context ID and metadata, and into delegated routing responses as
+// one record per protocol in their metadata. Each request to the upstream is bounded by the given
+// timeout, where zero means no timeout. Concurrent requests for the same multihash are coalesced,
+// and their results are cached according to the provider result cache configuration.
+// May be specified multiple times. No upstreams are used by default.
+// See: WithResultCacheMaxSize.
+func WithIpniUpstream(u string, timeout time.Duration) Option {
+	return func(o *options) error {
+		if timeout < 0 {
+			return errors.New("IPNI upstream timeout must not be negative")
+		}
+		upstream, err := newIpniUpstream(u, timeout)
+		if err != nil {
+			return err
+		}
+		o.ipniUpstreams = append(o.ipniUpstreams, upstream)
+		return nil
+	}
+}
+
 func WithMetricsEnablePprofDebug(b bool) Option {
 	return func(o *options) error {
 		o.metricsEnablePprofDebug = b
@@ -0,0 +1,282 @@
+package caskadht
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	peerFilterModeDeny  = "deny"
+	peerFilterModeAllow = "allow"
+
+	peerFilterAdminPath = "/admin/peer-filter"
+)
+
+type (
+	// peerFilter excludes providers from lookup results by peer ID. In deny mode the listed peers
+	// are excluded, and in allow mode all but the listed peers are excluded. The list may be
+	// loaded from a file with one peer ID per line, where blank lines and lines starting with "#"
+	// are ignored, and updated via the admin endpoint, in which case the file is rewritten.
+	peerFilter struct {
+		path  string
+		allow bool
+
+		lock    sync.RWMutex
+		modTime time.Time
+		ids     map[peer.ID]struct{}
+	}
+	// peerFilterState is the state of the peer filter, as returned by the admin endpoint.
+	peerFilterState struct {
+		Mode  string
+		Peers []peer.ID
+	}
+)
+
+func newPeerFilter(path string, allow bool) *peerFilter {
+	return &peerFilter{
+		path:  path,
+		allow: allow,
+		ids:   make(map[peer.ID]struct{}),
+	}
+}
+
+// load (re)loads the peer IDs from file, if any. The currently loaded peer IDs are kept if the
+// file cannot be loaded.
+func (f *peerFilter) load() error {
+	if f.path == "" {
+		return nil
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ids := make(map[peer.ID]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := peer.Decode(line)
+		if err != nil {
+			return fmt.Errorf("invalid peer ID %s: %w", line, err)
+		}
+		ids[id] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.modTime = info.ModTime()
+	f.ids = ids
+	logger.Infow("Loaded peer filter", "path", f.path, "mode", f.mode(), "peers", len(ids))
+	return nil
+}
+
+// reloadIfChanged reloads the peer IDs if the file has been modified since last loaded.
+func (f *peerFilter) reloadIfChanged() error {
+	if f.path == "" {
+		return nil
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.lock.RLock()
+	changed := !info.ModTime().Equal(f.modTime)
+	f.lock.RUnlock()
+	if !changed {
+		return nil
+	}
+	return f.load()
+}
+
+// allowed checks whether the given peer may be returned as a provider.
+func (f *peerFilter) allowed(id peer.ID) bool {
+	f.lock.RLock()
+	_, listed := f.ids[id]
+	f.lock.RUnlock()
+	return listed == f.allow
+}
+
+// update adds and removes the given peer IDs, and rewrites the file if any.
+func (f *peerFilter) update(add, remove []peer.ID) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ids := make(map[peer.ID]struct{}, len(f.ids)+len(add))
+	for id := range f.ids {
+		ids[id] = struct{}{}
+	}
+	for _, id := range add {
+		ids[id] = struct{}{}
+	}
+	for _, id := range remove {
+		delete(ids, id)
+	}
+	if f.path != "" {
+		modTime, err := writePeerFilterFile(f.path, sortedPeerIDs(ids))
+		if err != nil {
+			return err
+		}
+		f.modTime = modTime
+	}
+	f.ids = ids
+	return nil
+}
+
+func (f *peerFilter) state() peerFilterState {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return peerFilterState{
+		Mode:  f.mode(),
+		Peers: sortedPeerIDs(f.ids),
+	}
+}
+
+func (f *peerFilter) mode() string {
+	if f.allow {
+		return peerFilterModeAllow
+	}
+	return peerFilterModeDeny
+}
+
+func sortedPeerIDs(ids map[peer.ID]struct{}) []peer.ID {
+	sorted := make([]peer.ID, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// writePeerFilterFile atomically replaces the file at the given path with the given peer IDs,
+// returning the modification time of the written file.
+func writePeerFilterFile(path string, ids []peer.ID) (time.Time, error) {
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.WriteString(id.String())
+		buf.WriteByte('\n')
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return time.Time{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return time.Time{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// allowedProvider checks whether the given provider passes the peer filter, if configured,
+// recording the providers that do not.
+func (c *Caskadht) allowedProvider(ctx context.Context, id peer.ID) bool {
+	if c.peerFilter == nil || c.peerFilter.allowed(id) {
+		return true
+	}
+	logger.Debugw("Skipping provider excluded by peer filter", "id", id)
+	c.metrics.notifyPeerFilterExcluded(ctx, c.peerFilter.mode())
+	return false
+}
+
+// ReloadPeerFilter reloads the peer filter from file, if configured.
+// See: WithPeerFilterPath.
+func (c *Caskadht) ReloadPeerFilter() error {
+	if c.peerFilter == nil {
+		return nil
+	}
+	return c.peerFilter.load()
+}
+
+// watchPeerFilter periodically reloads the peer filter if its file has changed, until the server
+// is shut down.
+func (c *Caskadht) watchPeerFilter() {
+	ticker := time.NewTicker(c.peerFilterCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.peerFilter.reloadIfChanged(); err != nil {
+				logger.Errorw("Failed to reload peer filter", "path", c.peerFilter.path, "err", err)
+			}
+		}
+	}
+}
+
+// handlePeerFilterAdmin serves the peer filter admin endpoint, where:
+//   - GET returns the filter mode and the listed peer IDs,
+//   - PUT /<peer-id> adds the peer ID to the list, and
+//   - DELETE /<peer-id> removes the peer ID from the list.
+func (c *Caskadht) handlePeerFilterAdmin(w http.ResponseWriter, r *http.Request) {
+	if c.peerFilter == nil {
+		http.Error(w, "peer filter is not enabled", http.StatusNotFound)
+		return
+	}
+	arg := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, peerFilterAdminPath), "/")
+	switch r.Method {
+	case http.MethodGet:
+		if arg != "" {
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeJson)
+		if err := json.NewEncoder(w).Encode(c.peerFilter.state()); err != nil {
+			logger.Errorw("Failed to encode peer filter state", "err", err)
+		}
+	case http.MethodPut, http.MethodDelete:
+		id, err := peer.Decode(arg)
+		if err != nil {
+			http.Error(w, "invalid peer ID", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPut {
+			err = c.peerFilter.update([]peer.ID{id}, nil)
+		} else {
+			err = c.peerFilter.update(nil, []peer.ID{id})
+		}
+		if err != nil {
+			logger.Errorw("Failed to update peer filter", "id", id, "method", r.Method, "err", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		logger.Infow("Updated peer filter", "id", id, "method", r.Method)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPut)
+		w.Header().Add("Allow", http.MethodDelete)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
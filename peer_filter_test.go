@@ -0,0 +1,44 @@
+package caskadht
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_peerFilter(t *testing.T) {
+	p1 := test.RandPeerIDFatal(t)
+	p2 := test.RandPeerIDFatal(t)
+	p3 := test.RandPeerIDFatal(t)
+
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("# Abusive peers\n%s\n\n%s\n", p1, p2)), 0o600))
+
+	subject := newPeerFilter(path, false)
+	require.NoError(t, subject.load())
+	require.False(t, subject.allowed(p1))
+	require.False(t, subject.allowed(p2))
+	require.True(t, subject.allowed(p3))
+
+	// Update the list and assert the file is rewritten.
+	require.NoError(t, subject.update([]peer.ID{p3}, []peer.ID{p1}))
+	require.True(t, subject.allowed(p1))
+	require.False(t, subject.allowed(p3))
+	reloaded := newPeerFilter(path, true)
+	require.NoError(t, reloaded.load())
+	require.Equal(t, peerFilterState{Mode: peerFilterModeAllow, Peers: sortedPeerIDs(map[peer.ID]struct{}{p2: {}, p3: {}})}, reloaded.state())
+	require.True(t, reloaded.allowed(p2))
+	require.False(t, reloaded.allowed(p1))
+
+	// Invalid files must not replace the loaded list.
+	require.NoError(t, os.WriteFile(path, []byte("fish\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.Error(t, subject.reloadIfChanged())
+	require.False(t, subject.allowed(p3))
+}
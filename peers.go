@@ -0,0 +1,149 @@
+package caskadht
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/rwriter"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+const drPeersPathPrefix = "/routing/v1/peers/"
+
+type (
+	// delegatedRoutingPeerResponseWriter writes the response of delegated routing peer lookups.
+	// See: https://specs.ipfs.tech/routing/http-routing-v1/#get-routing-v1-peers-peer-id
+	delegatedRoutingPeerResponseWriter struct {
+		rwriter.ResponseWriter
+		id     peer.ID
+		filter *queryFilter
+	}
+	drPeerRecords struct {
+		Peers []drPeerRecord
+	}
+)
+
+func newDelegatedRoutingPeerResponseWriter(w http.ResponseWriter, r *http.Request, preferJson bool) (*delegatedRoutingPeerResponseWriter, error) {
+	if !strings.HasPrefix(r.URL.Path, drPeersPathPrefix) {
+		return nil, apierror.New(nil, http.StatusNotFound)
+	}
+	// Peer IDs may be specified either as base58 multihashes or as CIDs with libp2p-key codec.
+	id, err := peer.Decode(strings.TrimSpace(path.Base(r.URL.Path)))
+	if err != nil {
+		return nil, apierror.New(err, http.StatusBadRequest)
+	}
+	// Normalise the path to the base58 form, which is parsed as a multihash by rwriter.
+	nr := r.Clone(r.Context())
+	nr.URL.Path = drPeersPathPrefix + id.String()
+	rspWriter, err := rwriter.New(w, nr,
+		rwriter.WithPreferJson(preferJson),
+		rwriter.WithMultihashPathType("peers"),
+		rwriter.WithCidPathType(""),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &delegatedRoutingPeerResponseWriter{
+		ResponseWriter: *rspWriter,
+		id:             id,
+		filter:         newQueryFilter(r.URL.Query()),
+	}, nil
+}
+
+// writePeer writes the given peer, unless none of its addrs pass the requested filter. An error
+// with status 404 Not Found is returned if nothing is written.
+func (d *delegatedRoutingPeerResponseWriter) writePeer(found peer.AddrInfo) error {
+	addrs, ok := d.filter.filterAddrs(found.Addrs)
+	if !ok {
+		return apierror.New(nil, http.StatusNotFound)
+	}
+	rec := drPeerRecord{
+		Schema: DelegatedRoutingSchemaPeer,
+		ID:     found.ID,
+		Addrs:  addrs,
+	}
+	if d.IsND() {
+		if err := d.Encoder().Encode(rec); err != nil {
+			logger.Errorw("Failed to encode ndjson response", "err", err)
+			return err
+		}
+		d.Flush()
+		return nil
+	}
+	return d.Encoder().Encode(drPeerRecords{Peers: []drPeerRecord{rec}})
+}
+
+func (c *Caskadht) handleRoutingV1PeersSubtree(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		pw, err := newDelegatedRoutingPeerResponseWriter(w, r, c.httpResponsePreferJson)
+		if err != nil {
+			var apiErr *apierror.Error
+			if errors.As(err, &apiErr) {
+				http.Error(w, "", apiErr.Status())
+				return
+			}
+			logger.Errorw("Cannot handle delegated routing peer lookup", "err", err)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		b, _, _ := c.backendForRequest(r)
+		c.handleDrPeerLookup(pw, r, b)
+	case http.MethodOptions:
+		c.handleLookupOptions(w, "GET, OPTIONS")
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodOptions)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *Caskadht) handleDrPeerLookup(w *delegatedRoutingPeerResponseWriter, r *http.Request, b *dhtBackend) {
+	start := time.Now()
+	found, err := c.findPeer(r.Context(), b, w.id)
+	c.metrics.notifyPeerLookupResponded(context.Background(), err, time.Since(start))
+	if err == nil {
+		err = w.writePeer(found)
+	}
+	if err != nil {
+		var apiErr *apierror.Error
+		switch {
+		case errors.As(err, &apiErr):
+			http.Error(w, "", apiErr.Status())
+		case errors.Is(err, routing.ErrNotFound):
+			http.Error(w, "", http.StatusNotFound)
+		default:
+			logger.Errorw("Failed to find peer", "id", w.id, "err", err)
+			http.Error(w, "", http.StatusInternalServerError)
+		}
+	}
+}
+
+// findPeer finds the addrs of the given peer from the local peerstore, or failing that from the
+// given backend, filtered by the configured AddrFilter. Returns routing.ErrNotFound if no addrs
+// are found.
+func (c *Caskadht) findPeer(ctx context.Context, b *dhtBackend, id peer.ID) (peer.AddrInfo, error) {
+	found := peer.AddrInfo{ID: id, Addrs: c.filterAddrs(c.h.Peerstore().Addrs(id))}
+	if len(found.Addrs) != 0 {
+		return found, nil
+	}
+	routed, err := b.routing().FindPeer(ctx, id)
+	if err != nil {
+		return found, err
+	}
+	if len(routed.Addrs) != 0 {
+		c.h.Peerstore().AddAddrs(routed.ID, routed.Addrs, peerstore.AddressTTL)
+	}
+	found.Addrs = c.filterAddrs(routed.Addrs)
+	if len(found.Addrs) == 0 {
+		return found, routing.ErrNotFound
+	}
+	return found, nil
+}
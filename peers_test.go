@@ -0,0 +1,78 @@
+package caskadht
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipni/go-libipni/apierror"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_delegatedRoutingPeerResponseWriter(t *testing.T) {
+	id, err := peer.Decode("12D3KooWGRHcWNxdn5qmtwfVDKBFQMjhVdnNYHnAkbUBuUGqZTcf")
+	require.NoError(t, err)
+	found := peer.AddrInfo{
+		ID: id,
+		Addrs: []multiaddr.Multiaddr{
+			multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+			multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"),
+		},
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   string
+	}{
+		{
+			name:   "json",
+			target: "/routing/v1/peers/" + id.String(),
+			accept: mediaTypeJson,
+			want:   `{"Peers":[{"Schema":"peer","ID":"12D3KooWGRHcWNxdn5qmtwfVDKBFQMjhVdnNYHnAkbUBuUGqZTcf","Addrs":["/ip4/1.2.3.4/tcp/4001","/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"]}]}` + "\n",
+		},
+		{
+			name:   "ndjson by cid",
+			target: "/routing/v1/peers/" + peer.ToCid(id).String(),
+			accept: mediaTypeNDJson,
+			want:   `{"Schema":"peer","ID":"12D3KooWGRHcWNxdn5qmtwfVDKBFQMjhVdnNYHnAkbUBuUGqZTcf","Addrs":["/ip4/1.2.3.4/tcp/4001","/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"]}` + "\n",
+		},
+		{
+			name:   "filtered",
+			target: "/routing/v1/peers/" + id.String() + "?filter-addrs=webtransport",
+			accept: mediaTypeJson,
+			want:   `{"Peers":[{"Schema":"peer","ID":"12D3KooWGRHcWNxdn5qmtwfVDKBFQMjhVdnNYHnAkbUBuUGqZTcf","Addrs":["/ip4/1.2.3.4/udp/4001/quic-v1/webtransport"]}]}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			w, err := newDelegatedRoutingPeerResponseWriter(rec, r, false)
+			require.NoError(t, err)
+			require.Equal(t, id, w.id)
+			require.NoError(t, w.writePeer(found))
+			require.Equal(t, tt.accept, rec.Header().Get("Content-Type"))
+			require.Equal(t, tt.want, rec.Body.String())
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/routing/v1/peers/"+id.String()+"?filter-addrs=webrtc-direct", nil)
+	r.Header.Set("Accept", mediaTypeJson)
+	w, err := newDelegatedRoutingPeerResponseWriter(httptest.NewRecorder(), r, false)
+	require.NoError(t, err)
+	var apiErr *apierror.Error
+	require.True(t, errors.As(w.writePeer(found), &apiErr))
+	require.Equal(t, http.StatusNotFound, apiErr.Status())
+
+	r = httptest.NewRequest(http.MethodGet, "/routing/v1/peers/fish", nil)
+	r.Header.Set("Accept", mediaTypeJson)
+	_, err = newDelegatedRoutingPeerResponseWriter(httptest.NewRecorder(), r, false)
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusBadRequest, apiErr.Status())
+}
@@ -0,0 +1,264 @@
+package caskadht
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// httpProvideMaxBodySize is the maximum size of delegated routing provide requests in bytes.
+	httpProvideMaxBodySize = 1 << 20
+	// httpProvideMaxClockSkew is the maximum duration by which the timestamp of provided records
+	// may be ahead of the local clock.
+	httpProvideMaxClockSkew = 10 * time.Minute
+	// httpProvideExpiryInterval is the interval at which expired provides are deleted.
+	httpProvideExpiryInterval = 10 * time.Minute
+)
+
+var (
+	errProvideKeyBlocked    = errors.New("key is blocked")
+	errProvideNotAllowed    = errors.New("provider is not allowed")
+	errProvideNoKeys        = errors.New("no keys to provide")
+	errProvideNoTimestamp   = errors.New("timestamp must be specified")
+	errProvideExpired       = errors.New("record has expired")
+	errProvideFutureRecord  = errors.New("timestamp is too far in the future")
+	errProvideUnknownSchema = errors.New("unsupported schema")
+)
+
+// provideScopeLocal is the scope of provides, which are served by this instance only.
+const provideScopeLocal = "local"
+
+type (
+	// drProvideRequest is the body of delegated routing provide requests.
+	// See: https://specs.ipfs.tech/routing/http-routing-v1/#put-routing-v1-providers
+	drProvideRequest struct {
+		Providers []json.RawMessage
+	}
+	drProvideResponse struct {
+		ProvideResults []drProvideResult
+	}
+	// drProvideResult is the result of providing a single record, compatible with the bitswap
+	// write provider record response and extended with the outcome of providing each key.
+	drProvideResult struct {
+		Protocol    string
+		Schema      string
+		AdvisoryTTL *types.Duration      `json:",omitempty"`
+		Error       string               `json:",omitempty"`
+		Keys        []drProvideKeyResult `json:",omitempty"`
+	}
+	// drProvideKeyResult is the outcome of providing a single key. Scope is set to "local" once
+	// the key is provided, since provides are only served by this instance and are not announced
+	// to the DHT.
+	drProvideKeyResult struct {
+		CID   string
+		Scope string `json:",omitempty"`
+		Error string `json:",omitempty"`
+	}
+)
+
+// handlePutProviders accepts signed bitswap provider records, responding with the result of each
+// record in the order they were given. Records that fail verification are reported as failed
+// without affecting the others.
+func (c *Caskadht) handlePutProviders(w http.ResponseWriter, r *http.Request) {
+	if !c.httpProvideEnabled {
+		http.Error(w, "", http.StatusNotImplemented)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, _ := mime.ParseMediaType(ct); mediaType != mediaTypeJson {
+			http.Error(w, "content type must be "+mediaTypeJson, http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, httpProvideMaxBodySize+1))
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	if len(body) > httpProvideMaxBodySize {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req drProvideRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid provide request", http.StatusBadRequest)
+		return
+	}
+	b, _, _ := c.backendForRequest(r)
+	rsp := drProvideResponse{ProvideResults: make([]drProvideResult, 0, len(req.Providers))}
+	for _, raw := range req.Providers {
+		result := c.provideRecord(r.Context(), b, raw)
+		c.metrics.notifyHttpProvide(context.Background(), result.outcome())
+		rsp.ProvideResults = append(rsp.ProvideResults, result)
+	}
+	w.Header().Set("Content-Type", mediaTypeJson)
+	if err := json.NewEncoder(w).Encode(rsp); err != nil {
+		logger.Errorw("Failed to encode provide response", "err", err)
+	}
+}
+
+// provideRecord verifies the given provider record and provides each of its keys.
+func (c *Caskadht) provideRecord(ctx context.Context, b *dhtBackend, raw json.RawMessage) drProvideResult {
+	var result drProvideResult
+	var head types.UnknownProviderRecord
+	if err := json.Unmarshal(raw, &head); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Protocol = head.Protocol
+	result.Schema = head.Schema
+	if head.Schema != types.SchemaBitswap {
+		result.Error = errProvideUnknownSchema.Error()
+		return result
+	}
+	var rec types.WriteBitswapProviderRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	provider, ttl, err := c.verifyProvideRecord(ctx, &rec)
+	if err != nil {
+		logger.Debugw("Rejected provider record", "err", err)
+		result.Error = err.Error()
+		return result
+	}
+	result.AdvisoryTTL = &types.Duration{Duration: ttl}
+	stored := &storedProvide{
+		Provider: provider,
+		Expiry:   rec.Payload.Timestamp.Add(ttl),
+	}
+	for _, key := range rec.Payload.Keys {
+		keyResult := drProvideKeyResult{CID: key.String()}
+		if err := c.provideKey(ctx, b, key.Cid, stored); err != nil {
+			keyResult.Error = err.Error()
+		} else {
+			keyResult.Scope = provideScopeLocal
+		}
+		result.Keys = append(result.Keys, keyResult)
+	}
+	return result
+}
+
+// outcome returns "accepted" if all the keys of the record were provided, "partial" if only some
+// were, and "rejected" otherwise.
+func (r *drProvideResult) outcome() string {
+	var failed int
+	for _, k := range r.Keys {
+		if k.Error != "" {
+			failed++
+		}
+	}
+	switch {
+	case r.Error != "" || failed == len(r.Keys):
+		return "rejected"
+	case failed != 0:
+		return "partial"
+	default:
+		return "accepted"
+	}
+}
+
+// verifyProvideRecord verifies the signature and validity of the given record, returning the
+// provider it announces and the duration for which it is kept.
+func (c *Caskadht) verifyProvideRecord(ctx context.Context, rec *types.WriteBitswapProviderRecord) (peer.AddrInfo, time.Duration, error) {
+	if err := rec.Verify(); err != nil {
+		return peer.AddrInfo{}, 0, err
+	}
+	payload := rec.Payload
+	if len(payload.Keys) == 0 {
+		return peer.AddrInfo{}, 0, errProvideNoKeys
+	}
+	if payload.Timestamp == nil {
+		return peer.AddrInfo{}, 0, errProvideNoTimestamp
+	}
+	now := time.Now()
+	if payload.Timestamp.After(now.Add(httpProvideMaxClockSkew)) {
+		return peer.AddrInfo{}, 0, errProvideFutureRecord
+	}
+	ttl := c.httpProvideMaxTTL
+	if payload.AdvisoryTTL != nil && payload.AdvisoryTTL.Duration > 0 && payload.AdvisoryTTL.Duration < ttl {
+		ttl = payload.AdvisoryTTL.Duration
+	}
+	if !payload.Timestamp.Add(ttl).After(now) {
+		return peer.AddrInfo{}, 0, errProvideExpired
+	}
+	id := *payload.ID
+	if !c.allowedProvider(ctx, id) {
+		return peer.AddrInfo{}, 0, errProvideNotAllowed
+	}
+	provider := peer.AddrInfo{ID: id}
+	for _, addr := range payload.Addrs {
+		if addr.Multiaddr != nil {
+			provider.Addrs = append(provider.Addrs, addr.Multiaddr)
+		}
+	}
+	return provider, ttl, nil
+}
+
+// provideKey persists the given provide of the given key, from which it is served in the lookup
+// responses of this instance only. Note that the provide is not announced to the DHT, since DHT
+// peers only accept provider records from the providing peer itself.
+func (c *Caskadht) provideKey(ctx context.Context, b *dhtBackend, key cid.Cid, p *storedProvide) error {
+	if c.blocked(ctx, key, denylistAPIDelegatedRouting) {
+		return errProvideKeyBlocked
+	}
+	if err := b.provides.put(ctx, key.Hash(), p); err != nil {
+		if errors.Is(err, errProvidePeerLimit) || errors.Is(err, errProvideTotalLimit) {
+			return err
+		}
+		logger.Errorw("Failed to store provide", "key", key, "provider", p.Provider.ID, "err", err)
+		return fmt.Errorf("failed to store provide: %w", err)
+	}
+	return nil
+}
+
+// expireProvides periodically deletes the expired provides of the given backend, until the server
+// is shut down. Expired provides are also deleted as they are encountered by lookups.
+func (c *Caskadht) expireProvides(b *dhtBackend) {
+	ticker := time.NewTicker(httpProvideExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.provides.deleteExpired(c.ctx); err != nil {
+				logger.Errorw("Failed to delete expired provides", "backend", b.label, "err", err)
+			}
+		}
+	}
+}
+
+// httpProvidedProviders returns the providers of the given key accepted via the delegated routing
+// HTTP provide API, filtered by the configured peer filter and AddrFilter.
+func (c *Caskadht) httpProvidedProviders(ctx context.Context, b *dhtBackend, key cid.Cid) []peer.AddrInfo {
+	if b.provides == nil {
+		return nil
+	}
+	providers, err := b.provides.getProviders(ctx, key.Hash())
+	if err != nil {
+		logger.Errorw("Failed to get provides", "key", key, "err", err)
+	}
+	filtered := providers[:0]
+	for _, provider := range providers {
+		if !c.allowedProvider(ctx, provider.ID) {
+			continue
+		}
+		addrs := c.filterAddrs(provider.Addrs)
+		if len(provider.Addrs) != 0 && len(addrs) == 0 {
+			continue
+		}
+		filtered = append(filtered, peer.AddrInfo{ID: provider.ID, Addrs: addrs})
+	}
+	return filtered
+}
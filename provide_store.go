@@ -0,0 +1,188 @@
+package caskadht
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	provideStorePrefix = datastore.NewKey("/http-provides")
+
+	errProvidePeerLimit  = errors.New("too many provides by provider")
+	errProvideTotalLimit = errors.New("too many provides")
+)
+
+type (
+	// provideStore persists the provider records accepted via the delegated routing HTTP provide
+	// API, keyed by multihash and provider ID. The number of stored provides is bounded both per
+	// provider and in total.
+	provideStore struct {
+		ds         datastore.Datastore
+		maxPerPeer int
+		maxTotal   int
+
+		// lock guards counts and total, and serialises puts so that limits are enforced.
+		lock   sync.Mutex
+		counts map[peer.ID]int
+		total  int
+	}
+	// storedProvide is a provide that is kept until its expiry, i.e. the record timestamp plus
+	// its TTL.
+	storedProvide struct {
+		Provider peer.AddrInfo
+		Expiry   time.Time
+	}
+)
+
+func newProvideStore(ds datastore.Datastore, maxPerPeer, maxTotal int) *provideStore {
+	return &provideStore{
+		ds:         ds,
+		maxPerPeer: maxPerPeer,
+		maxTotal:   maxTotal,
+		counts:     make(map[peer.ID]int),
+	}
+}
+
+// load counts the unexpired provides persisted in the datastore, and deletes the expired ones.
+func (s *provideStore) load(ctx context.Context) error {
+	s.lock.Lock()
+	s.counts = make(map[peer.ID]int)
+	s.total = 0
+	s.lock.Unlock()
+	return s.forEach(ctx, provideStorePrefix, func(_ multihash.Multihash, p *storedProvide) {
+		s.lock.Lock()
+		s.counts[p.Provider.ID]++
+		s.total++
+		s.lock.Unlock()
+	})
+}
+
+// deleteExpired deletes all the expired provides.
+func (s *provideStore) deleteExpired(ctx context.Context) error {
+	return s.forEach(ctx, provideStorePrefix, func(multihash.Multihash, *storedProvide) {})
+}
+
+func (s *provideStore) keyPrefix(key multihash.Multihash) datastore.Key {
+	return provideStorePrefix.ChildString(key.B58String())
+}
+
+func (s *provideStore) key(key multihash.Multihash, id peer.ID) datastore.Key {
+	return s.keyPrefix(key).ChildString(id.String())
+}
+
+// put stores the given provide, replacing any previously stored one by the same provider. New
+// provides that would exceed the per provider or total limit are rejected.
+func (s *provideStore) put(ctx context.Context, key multihash.Multihash, p *storedProvide) error {
+	v, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	dsKey := s.key(key, p.Provider.ID)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	exists, err := s.ds.Has(ctx, dsKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		switch {
+		case s.counts[p.Provider.ID] >= s.maxPerPeer:
+			return errProvidePeerLimit
+		case s.total >= s.maxTotal:
+			return errProvideTotalLimit
+		}
+	}
+	if err := s.ds.Put(ctx, dsKey, v); err != nil {
+		return err
+	}
+	if !exists {
+		s.counts[p.Provider.ID]++
+		s.total++
+	}
+	return nil
+}
+
+// getProviders returns the providers of the given multihash with unexpired provides, and deletes
+// the expired ones.
+func (s *provideStore) getProviders(ctx context.Context, key multihash.Multihash) ([]peer.AddrInfo, error) {
+	var providers []peer.AddrInfo
+	err := s.forEach(ctx, s.keyPrefix(key), func(_ multihash.Multihash, p *storedProvide) {
+		providers = append(providers, p.Provider)
+	})
+	return providers, err
+}
+
+// forEach calls f for every unexpired provide with the given key prefix, and deletes the expired
+// ones.
+func (s *provideStore) forEach(ctx context.Context, prefix datastore.Key, f func(multihash.Multihash, *storedProvide)) error {
+	results, err := s.ds.Query(ctx, query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	var expired []datastore.Key
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		key := datastore.NewKey(r.Key)
+		mh, p, err := s.decode(key, r.Value)
+		if err != nil {
+			logger.Warnw("Failed to process stored provide; deleting it", "key", key, "err", err)
+			expired = append(expired, key)
+			continue
+		}
+		if time.Now().After(p.Expiry) {
+			expired = append(expired, key)
+			continue
+		}
+		f(mh, p)
+	}
+	for _, key := range expired {
+		if err := s.delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete deletes the provide with the given datastore key, and releases its count towards the
+// limits.
+func (s *provideStore) delete(ctx context.Context, key datastore.Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	exists, err := s.ds.Has(ctx, key)
+	if err != nil || !exists {
+		return err
+	}
+	if err := s.ds.Delete(ctx, key); err != nil {
+		return err
+	}
+	if id, err := peer.Decode(key.BaseNamespace()); err == nil && s.counts[id] > 0 {
+		if s.counts[id]--; s.counts[id] == 0 {
+			delete(s.counts, id)
+		}
+		s.total--
+	}
+	return nil
+}
+
+func (s *provideStore) decode(key datastore.Key, v []byte) (multihash.Multihash, *storedProvide, error) {
+	mh, err := multihash.FromB58String(key.Parent().BaseNamespace())
+	if err != nil {
+		return nil, nil, err
+	}
+	var p storedProvide
+	if err := json.Unmarshal(v, &p); err != nil {
+		return nil, nil, err
+	}
+	return mh, &p, nil
+}
@@ -0,0 +1,264 @@
+package caskadht
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_provideRecord(t *testing.T) {
+	key := cid.MustParse("bafybeihvvulpp4evxj7x7armbqcyg6uezzuig6jp3lktpbovlqfkuqeuoq")
+	addr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	otherSk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	otherID, err := peer.IDFromPrivateKey(otherSk)
+	require.NoError(t, err)
+
+	newRecord := func(t *testing.T, schema string, timestamp time.Time, ttl time.Duration) *types.WriteBitswapProviderRecord {
+		rec := &types.WriteBitswapProviderRecord{
+			Protocol: "transport-bitswap",
+			Schema:   schema,
+			Payload: types.BitswapPayload{
+				Keys:        []types.CID{{Cid: key}},
+				Timestamp:   &types.Time{Time: timestamp},
+				AdvisoryTTL: &types.Duration{Duration: ttl},
+				ID:          &id,
+				Addrs:       []types.Multiaddr{{Multiaddr: addr}},
+			},
+		}
+		require.NoError(t, rec.Sign(id, sk))
+		return rec
+	}
+
+	tests := []struct {
+		name      string
+		record    func(t *testing.T) *types.WriteBitswapProviderRecord
+		wantErr   string
+		wantTTL   time.Duration
+		wantFound bool
+	}{
+		{
+			name: "valid",
+			record: func(t *testing.T) *types.WriteBitswapProviderRecord {
+				return newRecord(t, types.SchemaBitswap, time.Now(), time.Hour)
+			},
+			wantTTL:   time.Hour,
+			wantFound: true,
+		},
+		{
+			name: "ttl capped",
+			record: func(t *testing.T) *types.WriteBitswapProviderRecord {
+				return newRecord(t, types.SchemaBitswap, time.Now(), 72*time.Hour)
+			},
+			wantTTL:   48 * time.Hour,
+			wantFound: true,
+		},
+		{
+			name: "signed by other peer",
+			record: func(t *testing.T) *types.WriteBitswapProviderRecord {
+				rec := newRecord(t, types.SchemaBitswap, time.Now(), time.Hour)
+				other := &types.WriteBitswapProviderRecord{
+					Protocol: rec.Protocol,
+					Schema:   rec.Schema,
+					Payload:  rec.Payload,
+				}
+				other.Payload.ID = &otherID
+				require.NoError(t, other.Sign(otherID, otherSk))
+				rec.Signature = other.Signature
+				return rec
+			},
+			wantErr: "signature failed to verify",
+		},
+		{
+			name: "expired",
+			record: func(t *testing.T) *types.WriteBitswapProviderRecord {
+				return newRecord(t, types.SchemaBitswap, time.Now().Add(-2*time.Hour), time.Hour)
+			},
+			wantErr: errProvideExpired.Error(),
+		},
+		{
+			name: "in the future",
+			record: func(t *testing.T) *types.WriteBitswapProviderRecord {
+				return newRecord(t, types.SchemaBitswap, time.Now().Add(time.Hour), time.Hour)
+			},
+			wantErr: errProvideFutureRecord.Error(),
+		},
+		{
+			name: "unknown schema",
+			record: func(t *testing.T) *types.WriteBitswapProviderRecord {
+				return newRecord(t, "fish", time.Now(), time.Hour)
+			},
+			wantErr: errProvideUnknownSchema.Error(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := &Caskadht{options: &options{httpProvideEnabled: true, httpProvideMaxTTL: 48 * time.Hour}}
+			b := &dhtBackend{provides: newProvideStore(sync.MutexWrap(datastore.NewMapDatastore()), 10, 10)}
+			raw, err := json.Marshal(test.record(t))
+			require.NoError(t, err)
+
+			got := subject.provideRecord(context.Background(), b, raw)
+			if test.wantErr != "" {
+				require.Equal(t, test.wantErr, got.Error)
+				require.Equal(t, "rejected", got.outcome())
+			} else {
+				require.Empty(t, got.Error)
+				require.Equal(t, test.wantTTL, got.AdvisoryTTL.Duration)
+				require.Equal(t, []drProvideKeyResult{{CID: key.String(), Scope: provideScopeLocal}}, got.Keys)
+				require.Equal(t, "accepted", got.outcome())
+			}
+
+			providers := subject.httpProvidedProviders(context.Background(), b, key)
+			if test.wantFound {
+				require.Equal(t, []peer.AddrInfo{{ID: id, Addrs: []multiaddr.Multiaddr{addr}}}, providers)
+			} else {
+				require.Empty(t, providers)
+			}
+		})
+	}
+}
+
+func Test_provideStoreDeletesExpired(t *testing.T) {
+	key := cid.MustParse("bafybeihvvulpp4evxj7x7armbqcyg6uezzuig6jp3lktpbovlqfkuqeuoq").Hash()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	subject := newProvideStore(ds, 10, 10)
+	ctx := context.Background()
+	expired := test.RandPeerIDFatal(t)
+	unexpired := test.RandPeerIDFatal(t)
+
+	require.NoError(t, subject.put(ctx, key, &storedProvide{
+		Provider: peer.AddrInfo{ID: expired},
+		Expiry:   time.Now().Add(-time.Minute),
+	}))
+	require.NoError(t, subject.put(ctx, key, &storedProvide{
+		Provider: peer.AddrInfo{ID: unexpired},
+		Expiry:   time.Now().Add(time.Minute),
+	}))
+
+	got, err := subject.getProviders(ctx, key)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, unexpired, got[0].ID)
+	has, err := ds.Has(ctx, subject.key(key, expired))
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func Test_provideStoreLimits(t *testing.T) {
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	subject := newProvideStore(ds, 2, 3)
+	ctx := context.Background()
+	first := test.RandPeerIDFatal(t)
+	second := test.RandPeerIDFatal(t)
+	keys := make([]multihash.Multihash, 3)
+	for i := range keys {
+		var err error
+		keys[i], err = multihash.Sum([]byte{byte(i)}, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+	}
+	provide := func(id peer.ID, expiry time.Time) *storedProvide {
+		return &storedProvide{Provider: peer.AddrInfo{ID: id}, Expiry: expiry}
+	}
+	unexpired := time.Now().Add(time.Hour)
+
+	require.NoError(t, subject.put(ctx, keys[0], provide(first, unexpired)))
+	require.NoError(t, subject.put(ctx, keys[1], provide(first, time.Now().Add(-time.Minute))))
+	require.ErrorIs(t, subject.put(ctx, keys[2], provide(first, unexpired)), errProvidePeerLimit)
+	// Replacing an existing provide must not count towards the limits.
+	require.NoError(t, subject.put(ctx, keys[0], provide(first, unexpired)))
+	require.NoError(t, subject.put(ctx, keys[0], provide(second, unexpired)))
+	require.ErrorIs(t, subject.put(ctx, keys[1], provide(second, unexpired)), errProvideTotalLimit)
+
+	// Deleting expired provides must release their count.
+	require.NoError(t, subject.deleteExpired(ctx))
+	require.NoError(t, subject.put(ctx, keys[2], provide(first, unexpired)))
+
+	// Counts must be restored from the datastore.
+	reloaded := newProvideStore(ds, 2, 3)
+	require.NoError(t, reloaded.load(ctx))
+	require.ErrorIs(t, reloaded.put(ctx, keys[1], provide(first, unexpired)), errProvidePeerLimit)
+	require.ErrorIs(t, reloaded.put(ctx, keys[1], provide(second, unexpired)), errProvideTotalLimit)
+}
+
+func Test_handlePutProviders(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{
+			name:       "disabled",
+			body:       `{"Providers":[]}`,
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name:        "unsupported content type",
+			enabled:     true,
+			contentType: "text/plain",
+			body:        `{"Providers":[]}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "invalid body",
+			enabled:    true,
+			body:       `fish`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no providers",
+			enabled:    true,
+			body:       `{"Providers":[]}`,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := &Caskadht{options: &options{httpProvideEnabled: test.enabled}}
+			subject.backends = []*dhtBackend{{}}
+			r := httptest.NewRequest(http.MethodPut, "/routing/v1/providers", strings.NewReader(test.body))
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+			w := httptest.NewRecorder()
+			subject.handleRoutingV1ProvidersSubtree(w, r)
+			require.Equal(t, test.wantStatus, w.Code)
+			if test.wantStatus == http.StatusOK {
+				require.JSONEq(t, `{"ProvideResults":[]}`, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_handleProvidersOptionsAllowsPut(t *testing.T) {
+	subject := &Caskadht{options: &options{httpAllowOrigin: "*"}}
+	subject.backends = []*dhtBackend{{label: "ipfs-dht"}}
+	r := httptest.NewRequest(http.MethodOptions, "/routing/v1/providers", nil)
+	w := httptest.NewRecorder()
+	subject.handleRoutingV1ProvidersSubtree(w, r)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, "GET, PUT, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+}
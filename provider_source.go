@@ -0,0 +1,83 @@
+package caskadht
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ProviderSource is a source of providers onto which lookups are cascaded, such as the DHT.
+// The providers found by all sources are merged, deduplicated by peer ID, and have their addrs
+// populated and filtered in the same way as the providers found on the DHT.
+// See: WithProviderSources.
+type ProviderSource interface {
+	// Name returns the name of the source, used to label metrics.
+	Name() string
+	// FindProviders finds the providers of the given key, up to the given limit if positive. The
+	// returned channel must be closed once the search completes or the given context is done.
+	FindProviders(ctx context.Context, key cid.Cid, limit int) <-chan peer.AddrInfo
+}
+
+const providerSourceDHT = "dht"
+
+// providerSourceFunc adapts a function to ProviderSource.
+type providerSourceFunc struct {
+	name string
+	find func(context.Context, cid.Cid, int) <-chan peer.AddrInfo
+}
+
+func (s providerSourceFunc) Name() string { return s.name }
+
+func (s providerSourceFunc) FindProviders(ctx context.Context, key cid.Cid, limit int) <-chan peer.AddrInfo {
+	return s.find(ctx, key, limit)
+}
+
+// providerSources returns the sources from which the providers of lookups on the given backend are
+// found, i.e. the DHT unless disabled, the delegated routing upstreams and the custom sources.
+func (c *Caskadht) providerSources(b *dhtBackend) []ProviderSource {
+	sources := make([]ProviderSource, 0, 1+len(c.drUpstreams)+len(c.customSources))
+	if !c.dhtProviderSourceDisabled {
+		sources = append(sources, providerSourceFunc{
+			name: providerSourceDHT,
+			find: func(ctx context.Context, key cid.Cid, _ int) <-chan peer.AddrInfo {
+				return c.findProvidersAsync(ctx, b, key)
+			},
+		})
+	}
+	for _, u := range c.drUpstreams {
+		u := u
+		sources = append(sources, providerSourceFunc{
+			name: u.url,
+			find: func(ctx context.Context, key cid.Cid, _ int) <-chan peer.AddrInfo {
+				return c.upstreamFindProviders(ctx, u, key)
+			},
+		})
+	}
+	return append(sources, c.customSources...)
+}
+
+// sourceFindProviders finds the providers of the given key from the given source, recording the
+// source metrics.
+func (c *Caskadht) sourceFindProviders(ctx context.Context, s ProviderSource, key cid.Cid) <-chan peer.AddrInfo {
+	rch := make(chan peer.AddrInfo, 1)
+	go func() {
+		defer close(rch)
+		start := time.Now()
+		var first bool
+		for provider := range s.FindProviders(ctx, key, c.findProvidersLimit) {
+			if !first {
+				first = true
+				c.metrics.notifyProviderSourceFirstProvider(ctx, s.Name(), time.Since(start))
+			}
+			c.metrics.notifyProviderSourceProviderFound(ctx, s.Name())
+			select {
+			case <-ctx.Done():
+				return
+			case rch <- provider:
+			}
+		}
+	}()
+	return rch
+}
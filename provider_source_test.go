@@ -0,0 +1,48 @@
+package caskadht
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+type testProviderSource string
+
+func (s testProviderSource) Name() string { return string(s) }
+
+func (s testProviderSource) FindProviders(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
+
+func Test_providerSources(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+
+	_, err = newOptions(WithHost(h), WithDHTProviderSourceDisabled(true))
+	require.Error(t, err)
+
+	opts, err := newOptions(WithHost(h),
+		WithDelegatedRoutingUpstream("https://fish.invalid", 0),
+		WithProviderSources(testProviderSource("lobster")))
+	require.NoError(t, err)
+	c := &Caskadht{options: opts}
+	var names []string
+	for _, s := range c.providerSources(&dhtBackend{}) {
+		names = append(names, s.Name())
+	}
+	require.Equal(t, []string{providerSourceDHT, "https://fish.invalid", "lobster"}, names)
+
+	opts.dhtProviderSourceDisabled = true
+	names = nil
+	for _, s := range c.providerSources(&dhtBackend{}) {
+		names = append(names, s.Name())
+	}
+	require.Equal(t, []string{"https://fish.invalid", "lobster"}, names)
+}
@@ -0,0 +1,170 @@
+package caskadht
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	filterAddrsQueryKey     = "filter-addrs"
+	filterProtocolsQueryKey = "filter-protocols"
+	// filterUnknown matches providers with no addrs in filter-addrs, and records with no
+	// protocol in filter-protocols.
+	filterUnknown = "unknown"
+	// filterNegationPrefix marks a filter-addrs entry as excluding the addrs it matches.
+	filterNegationPrefix = "!"
+)
+
+// queryFilter filters the providers in a lookup response by addrs and transfer protocols, as
+// requested by the filter-addrs and filter-protocols query parameters. Unlike the server-wide
+// AddrFilter, it applies to a single request and is applied to results before they are written.
+// See: https://github.com/ipfs/specs/pull/484
+type queryFilter struct {
+	// includeAddrs are the multiaddr protocol names of which addrs must contain at least one,
+	// if any.
+	includeAddrs []string
+	// excludeAddrs are the multiaddr protocol names of which addrs must contain none.
+	excludeAddrs []string
+	// includeUnknownAddrs is whether to include providers with no addrs.
+	includeUnknownAddrs bool
+	// protocols are the transfer protocol names of which records must have one, if any.
+	protocols map[string]struct{}
+}
+
+// newQueryFilter parses the filter-addrs and filter-protocols query parameters of the given query,
+// each a comma separated list that may be specified multiple times.
+func newQueryFilter(query url.Values) *queryFilter {
+	var f queryFilter
+	for _, name := range splitQueryValues(query[filterAddrsQueryKey]) {
+		switch {
+		case name == filterUnknown:
+			f.includeUnknownAddrs = true
+		case strings.HasPrefix(name, filterNegationPrefix):
+			f.excludeAddrs = append(f.excludeAddrs, strings.TrimPrefix(name, filterNegationPrefix))
+		default:
+			f.includeAddrs = append(f.includeAddrs, name)
+		}
+	}
+	for _, name := range splitQueryValues(query[filterProtocolsQueryKey]) {
+		if f.protocols == nil {
+			f.protocols = make(map[string]struct{})
+		}
+		f.protocols[name] = struct{}{}
+	}
+	return &f
+}
+
+// filtersAddrs checks whether any addr filtering is requested.
+func (f *queryFilter) filtersAddrs() bool {
+	return len(f.includeAddrs) != 0 || len(f.excludeAddrs) != 0 || f.includeUnknownAddrs
+}
+
+// filterAddrs returns the given addrs that pass the requested addr filter, and whether the
+// provider with the given addrs should be included at all. Providers are excluded if none of their
+// addrs pass the filter, unless they have no addrs and unknown addrs are requested.
+func (f *queryFilter) filterAddrs(addrs []multiaddr.Multiaddr) ([]multiaddr.Multiaddr, bool) {
+	if !f.filtersAddrs() {
+		return addrs, true
+	}
+	if len(addrs) == 0 {
+		return addrs, f.includeUnknownAddrs
+	}
+	filtered := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if f.allowAddr(addr) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered, len(filtered) != 0
+}
+
+func (f *queryFilter) allowAddr(addr multiaddr.Multiaddr) bool {
+	included := len(f.includeAddrs) == 0
+	for _, p := range addr.Protocols() {
+		for _, name := range f.excludeAddrs {
+			if p.Name == name {
+				return false
+			}
+		}
+		if !included {
+			for _, name := range f.includeAddrs {
+				if p.Name == name {
+					included = true
+					break
+				}
+			}
+		}
+	}
+	return included
+}
+
+// allowProtocol checks whether records with the given transfer protocol pass the requested
+// protocol filter, where an empty protocol is matched by "unknown".
+func (f *queryFilter) allowProtocol(protocol string) bool {
+	if len(f.protocols) == 0 {
+		return true
+	}
+	if protocol == "" {
+		protocol = filterUnknown
+	}
+	_, found := f.protocols[protocol]
+	return found
+}
+
+// allowAnyProtocol checks whether records with any of the given transfer protocols pass the
+// requested protocol filter.
+func (f *queryFilter) allowAnyProtocol(protocols []string) bool {
+	if len(f.protocols) == 0 {
+		return true
+	}
+	if len(protocols) == 0 {
+		return f.allowProtocol("")
+	}
+	for _, protocol := range protocols {
+		if f.allowProtocol(protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterProviderResult returns the given IPNI provider result with its provider addrs filtered,
+// and whether it should be included at all according to the requested filters. The transfer
+// protocols of the result are derived from its metadata.
+func (f *queryFilter) filterProviderResult(result model.ProviderResult) (model.ProviderResult, bool) {
+	if len(f.protocols) != 0 {
+		var protocols []string
+		md := metadata.Default.New()
+		if err := md.UnmarshalBinary(result.Metadata); err == nil {
+			for _, code := range md.Protocols() {
+				protocols = append(protocols, code.String())
+			}
+		}
+		if !f.allowAnyProtocol(protocols) {
+			return result, false
+		}
+	}
+	if !f.filtersAddrs() || result.Provider == nil {
+		return result, true
+	}
+	addrs, ok := f.filterAddrs(result.Provider.Addrs)
+	result.Provider = &peer.AddrInfo{ID: result.Provider.ID, Addrs: addrs}
+	return result, ok
+}
+
+func splitQueryValues(values []string) []string {
+	var split []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				split = append(split, s)
+			}
+		}
+	}
+	return split
+}
@@ -0,0 +1,103 @@
+package caskadht
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryFilter(t *testing.T) {
+	tcp := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+	webtransport := multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1/webtransport")
+	webrtcDirect := multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/webrtc-direct")
+	relayed := multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1/webtransport/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN/p2p-circuit")
+	all := []multiaddr.Multiaddr{tcp, webtransport, webrtcDirect, relayed}
+
+	tests := []struct {
+		name      string
+		query     string
+		given     []multiaddr.Multiaddr
+		want      []multiaddr.Multiaddr
+		wantFound bool
+	}{
+		{
+			name:      "no filter",
+			given:     all,
+			want:      all,
+			wantFound: true,
+		},
+		{
+			name:      "include",
+			query:     "filter-addrs=webtransport,webrtc-direct",
+			given:     all,
+			want:      []multiaddr.Multiaddr{webtransport, webrtcDirect, relayed},
+			wantFound: true,
+		},
+		{
+			name:      "include and exclude",
+			query:     "filter-addrs=webtransport,webrtc-direct,!p2p-circuit",
+			given:     all,
+			want:      []multiaddr.Multiaddr{webtransport, webrtcDirect},
+			wantFound: true,
+		},
+		{
+			name:      "exclude across repeated params",
+			query:     "filter-addrs=!tcp&filter-addrs=!webrtc-direct",
+			given:     all,
+			want:      []multiaddr.Multiaddr{webtransport, relayed},
+			wantFound: true,
+		},
+		{
+			name:  "none left",
+			query: "filter-addrs=tcp",
+			given: []multiaddr.Multiaddr{webtransport},
+			want:  []multiaddr.Multiaddr{},
+		},
+		{
+			name:  "no addrs",
+			query: "filter-addrs=tcp",
+		},
+		{
+			name:      "unknown addrs",
+			query:     "filter-addrs=tcp,unknown",
+			wantFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			got, found := newQueryFilter(query).filterAddrs(tt.given)
+			require.Equal(t, tt.wantFound, found)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	query, err := url.ParseQuery("filter-protocols=transport-bitswap,unknown&filter-addrs=webtransport")
+	require.NoError(t, err)
+	subject := newQueryFilter(query)
+	require.True(t, subject.allowProtocol(multicodec.TransportBitswap.String()))
+	require.True(t, subject.allowProtocol(""))
+	require.False(t, subject.allowProtocol(multicodec.TransportGraphsyncFilecoinv1.String()))
+
+	provider := &peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: all}
+	got, found := subject.filterProviderResult(model.ProviderResult{Metadata: cascadeMetadata, Provider: provider})
+	require.True(t, found)
+	require.Equal(t, []multiaddr.Multiaddr{webtransport, relayed}, got.Provider.Addrs)
+	// The result provider must not be modified in place, since it may be shared.
+	require.Equal(t, all, provider.Addrs)
+
+	md := metadata.Default.New(&metadata.GraphsyncFilecoinV1{PieceCID: cid.NewCidV1(cid.Raw, []byte(provider.ID))})
+	gsMetadata, err := md.MarshalBinary()
+	require.NoError(t, err)
+	_, found = subject.filterProviderResult(model.ProviderResult{Metadata: gsMetadata, Provider: provider})
+	require.False(t, found)
+}
@@ -1,10 +1,14 @@
 package caskadht
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipni/go-libipni/apierror"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
 	"github.com/ipni/go-libipni/rwriter"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
@@ -13,28 +17,87 @@ import (
 
 var drProtocolBitswap = multicodec.TransportBitswap.String()
 
-const drSchemaBitswap = "bitswap"
+// DelegatedRoutingSchema is the schema of the records in delegated routing lookup responses.
+// See: WithDelegatedRoutingSchema.
+type DelegatedRoutingSchema string
+
+const (
+	// DelegatedRoutingSchemaPeer is the generic peer schema, where each record lists the transfer
+	// protocols supported by the provider.
+	// See: https://github.com/ipfs/specs/pull/417
+	DelegatedRoutingSchemaPeer DelegatedRoutingSchema = "peer"
+	// DelegatedRoutingSchemaBitswap is the deprecated bitswap schema, where each record has a
+	// single transfer protocol.
+	DelegatedRoutingSchemaBitswap DelegatedRoutingSchema = "bitswap"
+
+	// drSchemaQueryKey is the query parameter with which clients may override the schema of the
+	// response records. It is a caskadht-only extension of the delegated routing API, since the
+	// API offers no way for clients to negotiate the schema; standard clients always get the
+	// schema configured via WithDelegatedRoutingSchema.
+	drSchemaQueryKey = "schema"
+)
 
 type (
 	delegatedRoutingLookupResponseWriter struct {
 		rwriter.ResponseWriter
+		schema DelegatedRoutingSchema
 		result drProviderRecords
+		// seen tracks the written records by peer ID and protocol in order to avoid duplicates
+		// when the same provider is found by multiple sources.
+		seen map[drRecordKey]struct{}
+		// filter is the addr and protocol filter requested via query parameters.
+		filter *queryFilter
+	}
+	drRecordKey struct {
+		id       peer.ID
+		protocol string
 	}
 	drProviderRecords struct {
-		Providers []drProviderRecord
+		Providers []any
 	}
 	drProviderRecord struct {
 		Protocol string
 		Schema   string
 		ID       peer.ID
 		Addrs    []multiaddr.Multiaddr
+
+		// Fields specific to transport-graphsync-filecoinv1 protocol.
+		PieceCID      *cid.Cid `json:",omitempty"`
+		VerifiedDeal  bool     `json:",omitempty"`
+		FastRetrieval bool     `json:",omitempty"`
+	}
+	drPeerRecord struct {
+		Schema    DelegatedRoutingSchema
+		ID        peer.ID
+		Addrs     []multiaddr.Multiaddr
+		Protocols []string `json:",omitempty"`
+
+		// Metadata specific to transport-graphsync-filecoinv1 protocol, keyed by protocol name
+		// as an extra field.
+		GraphsyncFilecoinV1 *drGraphsyncFilecoinV1 `json:"transport-graphsync-filecoinv1,omitempty"`
+	}
+	drGraphsyncFilecoinV1 struct {
+		PieceCID      cid.Cid
+		VerifiedDeal  bool `json:",omitempty"`
+		FastRetrieval bool `json:",omitempty"`
 	}
 )
 
-func newDelegatedRoutingLookupResponseWriter(w http.ResponseWriter, r *http.Request, preferJson bool) (*delegatedRoutingLookupResponseWriter, error) {
+func newDelegatedRoutingLookupResponseWriter(w http.ResponseWriter, r *http.Request, preferJson bool, schema DelegatedRoutingSchema) (*delegatedRoutingLookupResponseWriter, error) {
 	if !strings.HasPrefix(r.URL.Path, "/routing/v1/providers/") {
 		return nil, apierror.New(nil, http.StatusNotFound)
 	}
+	if requested := r.URL.Query().Get(drSchemaQueryKey); requested != "" {
+		schema = DelegatedRoutingSchema(requested)
+		if err := schema.validate(); err != nil {
+			return nil, apierror.New(err, http.StatusBadRequest)
+		}
+	}
+	// Default to JSON, as specified by the delegated routing API, for clients that do not set the
+	// Accept header, e.g. the boxo delegated routing client.
+	if r.Header.Get("Accept") == "" {
+		r.Header.Set("Accept", mediaTypeJson)
+	}
 	rspWriter, err := rwriter.New(w, r,
 		rwriter.WithPreferJson(preferJson),
 		rwriter.WithMultihashPathType(""),
@@ -45,16 +108,92 @@ func newDelegatedRoutingLookupResponseWriter(w http.ResponseWriter, r *http.Requ
 	}
 	return &delegatedRoutingLookupResponseWriter{
 		ResponseWriter: *rspWriter,
+		schema:         schema,
+		seen:           make(map[drRecordKey]struct{}),
+		filter:         newQueryFilter(r.URL.Query()),
 	}, nil
 }
 
+func (s DelegatedRoutingSchema) validate() error {
+	switch s {
+	case DelegatedRoutingSchemaPeer, DelegatedRoutingSchemaBitswap:
+		return nil
+	default:
+		return fmt.Errorf("unknown delegated routing schema: %s", s)
+	}
+}
+
 func (d *delegatedRoutingLookupResponseWriter) writeDrProviderRecord(provider peer.AddrInfo) error {
-	rec := drProviderRecord{
+	return d.writeDrRecords(drProviderRecord{
 		Protocol: drProtocolBitswap,
-		Schema:   drSchemaBitswap,
+		Schema:   string(DelegatedRoutingSchemaBitswap),
 		ID:       provider.ID,
 		Addrs:    provider.Addrs,
+	})
+}
+
+// writeIpniProviderResult writes the protocols in the metadata of the given IPNI provider result,
+// either as a single peer schema record or as one record per protocol. Results with unparsable
+// metadata are skipped.
+func (d *delegatedRoutingLookupResponseWriter) writeIpniProviderResult(result model.ProviderResult) error {
+	md := metadata.Default.New()
+	if err := md.UnmarshalBinary(result.Metadata); err != nil {
+		logger.Debugw("Skipping IPNI provider result with invalid metadata", "id", result.Provider.ID, "err", err)
+		return nil
 	}
+	recs := make([]drProviderRecord, 0, md.Len())
+	for _, code := range md.Protocols() {
+		rec := drProviderRecord{
+			Protocol: code.String(),
+			// Derive the schema from the protocol name, e.g. "bitswap" for "transport-bitswap".
+			Schema: strings.TrimPrefix(code.String(), "transport-"),
+			ID:     result.Provider.ID,
+			Addrs:  result.Provider.Addrs,
+		}
+		if gs, ok := md.Get(code).(*metadata.GraphsyncFilecoinV1); ok {
+			rec.PieceCID = &gs.PieceCID
+			rec.VerifiedDeal = gs.VerifiedDeal
+			rec.FastRetrieval = gs.FastRetrieval
+		}
+		recs = append(recs, rec)
+	}
+	return d.writeDrRecords(recs...)
+}
+
+// writeDrRecords writes the given records of the same provider that pass the requested filters and
+// have not already been written. In peer schema the records are combined into a single record.
+func (d *delegatedRoutingLookupResponseWriter) writeDrRecords(recs ...drProviderRecord) error {
+	kept := recs[:0:0]
+	for _, rec := range recs {
+		if !d.filter.allowProtocol(rec.Protocol) {
+			continue
+		}
+		var ok bool
+		if rec.Addrs, ok = d.filter.filterAddrs(rec.Addrs); !ok {
+			continue
+		}
+		key := drRecordKey{id: rec.ID, protocol: rec.Protocol}
+		if _, found := d.seen[key]; found {
+			continue
+		}
+		d.seen[key] = struct{}{}
+		kept = append(kept, rec)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if d.schema == DelegatedRoutingSchemaBitswap {
+		for _, rec := range kept {
+			if err := d.write(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return d.write(newDrPeerRecord(kept))
+}
+
+func (d *delegatedRoutingLookupResponseWriter) write(rec any) error {
 	if d.IsND() {
 		if err := d.Encoder().Encode(rec); err != nil {
 			logger.Errorw("Failed to encode ndjson response", "err", err)
@@ -73,3 +212,25 @@ func (d *delegatedRoutingLookupResponseWriter) close() error {
 	}
 	return d.Encoder().Encode(d.result)
 }
+
+// newDrPeerRecord combines the given non-empty records of the same provider into a peer schema
+// record.
+func newDrPeerRecord(recs []drProviderRecord) drPeerRecord {
+	rec := drPeerRecord{
+		Schema:    DelegatedRoutingSchemaPeer,
+		ID:        recs[0].ID,
+		Addrs:     recs[0].Addrs,
+		Protocols: make([]string, 0, len(recs)),
+	}
+	for _, r := range recs {
+		rec.Protocols = append(rec.Protocols, r.Protocol)
+		if r.PieceCID != nil {
+			rec.GraphsyncFilecoinV1 = &drGraphsyncFilecoinV1{
+				PieceCID:      *r.PieceCID,
+				VerifiedDeal:  r.VerifiedDeal,
+				FastRetrieval: r.FastRetrieval,
+			}
+		}
+	}
+	return rec
+}
@@ -0,0 +1,153 @@
+package caskadht
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	drclient "github.com/ipfs/boxo/routing/http/client"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "Whether to update the golden files in testdata.")
+
+func Test_delegatedRoutingLookupResponseWriter_golden(t *testing.T) {
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	key := cid.NewCidV1(cid.Raw, mh)
+	md := metadata.Default.New(metadata.Bitswap{}, &metadata.GraphsyncFilecoinV1{PieceCID: key, FastRetrieval: true})
+	mdBytes, err := md.MarshalBinary()
+	require.NoError(t, err)
+	p1, err := peer.Decode("12D3KooWGRHcWNxdn5qmtwfVDKBFQMjhVdnNYHnAkbUBuUGqZTcf")
+	require.NoError(t, err)
+	p2, err := peer.Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	require.NoError(t, err)
+	ipniProvider := peer.AddrInfo{
+		ID:    p1,
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")},
+	}
+	dhtProvider := peer.AddrInfo{
+		ID:    p2,
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/dns4/example.com/udp/443/quic-v1/webtransport")},
+	}
+
+	tests := []struct {
+		name   string
+		schema DelegatedRoutingSchema
+		query  string
+		accept string
+		golden string
+	}{
+		{
+			name:   "peer json",
+			schema: DelegatedRoutingSchemaPeer,
+			accept: mediaTypeJson,
+			golden: "dr_peer.json",
+		},
+		{
+			name:   "peer ndjson",
+			schema: DelegatedRoutingSchemaPeer,
+			accept: mediaTypeNDJson,
+			golden: "dr_peer.ndjson",
+		},
+		{
+			name:   "bitswap json",
+			schema: DelegatedRoutingSchemaBitswap,
+			accept: mediaTypeJson,
+			golden: "dr_bitswap.json",
+		},
+		{
+			name:   "bitswap ndjson requested by query",
+			schema: DelegatedRoutingSchemaPeer,
+			query:  "?schema=bitswap",
+			accept: mediaTypeNDJson,
+			golden: "dr_bitswap.ndjson",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/routing/v1/providers/"+key.String()+tt.query, nil)
+			r.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			w, err := newDelegatedRoutingLookupResponseWriter(rec, r, false, tt.schema)
+			require.NoError(t, err)
+			require.NoError(t, w.writeIpniProviderResult(model.ProviderResult{Metadata: mdBytes, Provider: &ipniProvider}))
+			require.NoError(t, w.writeDrProviderRecord(dhtProvider))
+			require.NoError(t, w.close())
+
+			golden := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, rec.Body.Bytes(), 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(want), rec.Body.String())
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/routing/v1/providers/"+key.String()+"?schema=fish", nil)
+	_, err = newDelegatedRoutingLookupResponseWriter(httptest.NewRecorder(), r, false, DelegatedRoutingSchemaPeer)
+	require.Error(t, err)
+}
+
+func Test_delegatedRoutingSchemaDecodedByBoxoClient(t *testing.T) {
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	key := cid.NewCidV1(cid.Raw, mh)
+	provider := peer.AddrInfo{
+		ID:    test.RandPeerIDFatal(t),
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")},
+	}
+
+	for _, schema := range []DelegatedRoutingSchema{DelegatedRoutingSchemaBitswap, DelegatedRoutingSchemaPeer} {
+		schema := schema
+		t.Run(string(schema), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				drWriter, err := newDelegatedRoutingLookupResponseWriter(w, r, false, schema)
+				require.NoError(t, err)
+				require.NoError(t, drWriter.writeDrProviderRecord(provider))
+				require.NoError(t, drWriter.close())
+			}))
+			t.Cleanup(server.Close)
+
+			c, err := drclient.New(server.URL)
+			require.NoError(t, err)
+			got, err := c.FindProviders(context.Background(), key)
+			require.NoError(t, err)
+			require.Len(t, got, 1)
+			require.Equal(t, string(schema), got[0].GetSchema())
+			switch record := got[0].(type) {
+			case *types.ReadBitswapProviderRecord:
+				require.Equal(t, DelegatedRoutingSchemaBitswap, schema)
+				require.Equal(t, provider.ID, *record.ID)
+				require.Equal(t, types.Multiaddr{Multiaddr: provider.Addrs[0]}, record.Addrs[0])
+			case *types.UnknownProviderRecord:
+				require.Equal(t, DelegatedRoutingSchemaPeer, schema)
+				var peerRecord struct {
+					ID        peer.ID
+					Addrs     []types.Multiaddr
+					Protocols []string
+				}
+				require.NoError(t, json.Unmarshal(record.Bytes, &peerRecord))
+				require.Equal(t, provider.ID, peerRecord.ID)
+				require.Equal(t, []types.Multiaddr{{Multiaddr: provider.Addrs[0]}}, peerRecord.Addrs)
+				require.Equal(t, []string{drProtocolBitswap}, peerRecord.Protocols)
+			default:
+				require.Failf(t, "unexpected record type", "%T", record)
+			}
+		})
+	}
+}
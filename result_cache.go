@@ -0,0 +1,131 @@
+package caskadht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+type (
+	providerResultCache struct {
+		lock                 sync.Mutex
+		lru                  *lru.Cache
+		maxAge               time.Duration
+		negativeMaxAge       time.Duration
+		staleWhileRevalidate time.Duration
+	}
+	cachedResult struct {
+		providers    []peer.AddrInfo
+		at           time.Time
+		revalidating bool
+	}
+)
+
+func newProviderResultCache(maxEntries int, maxAge, negativeMaxAge, staleWhileRevalidate time.Duration) *providerResultCache {
+	return &providerResultCache{
+		lru:                  lru.New(maxEntries),
+		maxAge:               maxAge,
+		negativeMaxAge:       negativeMaxAge,
+		staleWhileRevalidate: staleWhileRevalidate,
+	}
+}
+
+// get returns the cached providers for the given multihash, if any. A cached result with no
+// providers indicates that a lookup had found no providers. Entries older than the configured max
+// age, or negative max age when there are no providers, are stale and still returned for up to
+// the configured stale-while-revalidate duration; after that they are evicted and treated as
+// absent.
+//
+// The returned revalidate flag is true only for the first get of a stale entry, which signals
+// that the caller should refresh the entry in the background.
+func (p *providerResultCache) get(key multihash.Multihash) (providers []peer.AddrInfo, found bool, revalidate bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, found := p.lru.Get(string(key))
+	if !found {
+		return nil, false, false
+	}
+	if result, ok := v.(*cachedResult); ok && result != nil {
+		maxAge := p.maxAge
+		if len(result.providers) == 0 {
+			maxAge = p.negativeMaxAge
+		}
+		age := time.Since(result.at)
+		if age < maxAge {
+			return result.providers, true, false
+		}
+		if age < maxAge+p.staleWhileRevalidate {
+			revalidate = !result.revalidating
+			result.revalidating = true
+			return result.providers, true, revalidate
+		}
+	}
+	p.lru.Remove(string(key))
+	return nil, false, false
+}
+
+// endRevalidation marks the revalidation of the cached result for the given multihash as ended,
+// e.g. because it was dropped, so that the next get of a stale entry signals revalidation again.
+func (p *providerResultCache) endRevalidation(key multihash.Multihash) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if v, found := p.lru.Get(string(key)); found {
+		if result, ok := v.(*cachedResult); ok && result != nil {
+			result.revalidating = false
+		}
+	}
+}
+
+// ttl returns the remaining duration for which the cached result of the given multihash is
+// fresh, which is negative if the result is stale. False is returned if there is no such result.
+func (p *providerResultCache) ttl(key multihash.Multihash) (time.Duration, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, found := p.lru.Get(string(key))
+	if !found {
+		return 0, false
+	}
+	result, ok := v.(*cachedResult)
+	if !ok || result == nil {
+		return 0, false
+	}
+	maxAge := p.maxAge
+	if len(result.providers) == 0 {
+		maxAge = p.negativeMaxAge
+	}
+	return maxAge - time.Since(result.at), true
+}
+
+func (p *providerResultCache) put(key multihash.Multihash, providers []peer.AddrInfo) {
+	p.putAt(key, providers, time.Now())
+}
+
+// putAt caches the given providers as if they were found at the given time.
+func (p *providerResultCache) putAt(key multihash.Multihash, providers []peer.AddrInfo, at time.Time) {
+	if !p.cacheable(providers) {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lru.Add(string(key), &cachedResult{
+		providers: providers,
+		at:        at,
+	})
+}
+
+func (p *providerResultCache) cacheable(providers []peer.AddrInfo) bool {
+	return len(providers) != 0 || p.negativeMaxAge > 0
+}
+
+// expiry returns the time after which the given providers found at the given time are no longer
+// served from cache, including any stale-while-revalidate duration.
+func (p *providerResultCache) expiry(providers []peer.AddrInfo, at time.Time) time.Time {
+	maxAge := p.maxAge
+	if len(providers) == 0 {
+		maxAge = p.negativeMaxAge
+	}
+	return at.Add(maxAge + p.staleWhileRevalidate)
+}
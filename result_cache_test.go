@@ -0,0 +1,116 @@
+package caskadht
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_providerResultCache(t *testing.T) {
+	key, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	pid, err := peer.Decode("12D3KooWSnniGsyAF663gvHdqhyfJMCjWJv54cGSzcPiEMAfanvU")
+	require.NoError(t, err)
+	want := []peer.AddrInfo{{ID: pid}}
+
+	subject := newProviderResultCache(1, 100*time.Millisecond, 0, 0)
+	_, found, _ := subject.get(key)
+	require.False(t, found)
+
+	subject.put(key, want)
+	got, found, revalidate := subject.get(key)
+	require.True(t, found)
+	require.False(t, revalidate)
+	require.Equal(t, want, got)
+
+	time.Sleep(100 * time.Millisecond)
+	_, found, _ = subject.get(key)
+	require.False(t, found)
+}
+
+func Test_providerResultCacheNegative(t *testing.T) {
+	key, err := multihash.Sum([]byte("lobster"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	subject := newProviderResultCache(1, time.Hour, 0, 0)
+	subject.put(key, nil)
+	_, found, _ := subject.get(key)
+	require.False(t, found, "negative results must not be cached when disabled")
+
+	subject = newProviderResultCache(1, time.Hour, 100*time.Millisecond, 0)
+	subject.put(key, nil)
+	got, found, _ := subject.get(key)
+	require.True(t, found)
+	require.Empty(t, got)
+
+	time.Sleep(100 * time.Millisecond)
+	_, found, _ = subject.get(key)
+	require.False(t, found)
+}
+
+func Test_providerResultCacheStaleWhileRevalidate(t *testing.T) {
+	key, err := multihash.Sum([]byte("crab"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	pid, err := peer.Decode("12D3KooWSnniGsyAF663gvHdqhyfJMCjWJv54cGSzcPiEMAfanvU")
+	require.NoError(t, err)
+	want := []peer.AddrInfo{{ID: pid}}
+
+	subject := newProviderResultCache(1, 50*time.Millisecond, 0, 100*time.Millisecond)
+	subject.put(key, want)
+	ttl, found := subject.ttl(key)
+	require.True(t, found)
+	require.Positive(t, ttl)
+	require.LessOrEqual(t, ttl, 50*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// Only the first get of a stale entry must signal revalidation.
+	got, found, revalidate := subject.get(key)
+	require.True(t, found)
+	require.True(t, revalidate)
+	require.Equal(t, want, got)
+	got, found, revalidate = subject.get(key)
+	require.True(t, found)
+	require.False(t, revalidate)
+	require.Equal(t, want, got)
+
+	// Ending the revalidation without a refreshed result must allow another revalidation.
+	subject.endRevalidation(key)
+	_, found, revalidate = subject.get(key)
+	require.True(t, found)
+	require.True(t, revalidate)
+	ttl, found = subject.ttl(key)
+	require.True(t, found)
+	require.Negative(t, ttl)
+
+	time.Sleep(100 * time.Millisecond)
+	_, found, _ = subject.get(key)
+	require.False(t, found)
+}
+
+func Test_setCacheControl(t *testing.T) {
+	key, err := multihash.Sum([]byte("squid"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	pid, err := peer.Decode("12D3KooWSnniGsyAF663gvHdqhyfJMCjWJv54cGSzcPiEMAfanvU")
+	require.NoError(t, err)
+	subject := &Caskadht{options: &options{resultCacheMaxAge: time.Hour, resultCacheNegativeMaxAge: time.Minute}}
+	b := &dhtBackend{resultCache: newProviderResultCache(1, time.Hour, time.Minute, 0)}
+
+	// The outcome of cache misses is unknown, so they must not be cacheable.
+	w := httptest.NewRecorder()
+	subject.setCacheControl(w, b, key)
+	require.Empty(t, w.Header().Get("Cache-Control"))
+
+	b.resultCache.put(key, nil)
+	w = httptest.NewRecorder()
+	subject.setCacheControl(w, b, key)
+	require.Regexp(t, `^public, max-age=(59|60)$`, w.Header().Get("Cache-Control"))
+
+	b.resultCache.putAt(key, []peer.AddrInfo{{ID: pid}}, time.Now().Add(-30*time.Minute))
+	w = httptest.NewRecorder()
+	subject.setCacheControl(w, b, key)
+	require.Regexp(t, `^public, max-age=(1799|1800)$`, w.Header().Get("Cache-Control"))
+}
@@ -0,0 +1,149 @@
+package caskadht
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	resultStoreProvidersPrefix = datastore.NewKey("/providers")
+	resultStorePeersPrefix     = datastore.NewKey("/peers")
+)
+
+type (
+	// resultStore persists provider lookup results and discovered peer addrs in a datastore, so
+	// that they survive restarts.
+	resultStore struct {
+		ds datastore.Datastore
+	}
+	storedResult struct {
+		Providers []peer.AddrInfo
+		At        time.Time
+		Expiry    time.Time
+	}
+	storedPeer struct {
+		AddrInfo peer.AddrInfo
+		Expiry   time.Time
+	}
+)
+
+func newResultStore(ds datastore.Datastore) *resultStore {
+	return &resultStore{ds: ds}
+}
+
+func (s *resultStore) providersKey(key multihash.Multihash) datastore.Key {
+	return resultStoreProvidersPrefix.ChildString(key.B58String())
+}
+
+func (s *resultStore) peerKey(id peer.ID) datastore.Key {
+	return resultStorePeersPrefix.ChildString(id.String())
+}
+
+// getProviders returns the stored result for the given multihash, if any. Expired results are
+// deleted and treated as absent.
+func (s *resultStore) getProviders(ctx context.Context, key multihash.Multihash) (*storedResult, bool, error) {
+	dsKey := s.providersKey(key)
+	v, err := s.ds.Get(ctx, dsKey)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	var result storedResult
+	if err := json.Unmarshal(v, &result); err != nil {
+		return nil, false, err
+	}
+	if time.Now().After(result.Expiry) {
+		return nil, false, s.ds.Delete(ctx, dsKey)
+	}
+	return &result, true, nil
+}
+
+func (s *resultStore) putProviders(ctx context.Context, key multihash.Multihash, result *storedResult) error {
+	v, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, s.providersKey(key), v)
+}
+
+func (s *resultStore) putPeer(ctx context.Context, p *storedPeer) error {
+	v, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, s.peerKey(p.AddrInfo.ID), v)
+}
+
+// forEachProviders calls f for every unexpired stored provider result, and deletes the expired
+// ones.
+func (s *resultStore) forEachProviders(ctx context.Context, f func(multihash.Multihash, *storedResult)) error {
+	return s.forEach(ctx, resultStoreProvidersPrefix, func(key datastore.Key, v []byte) (bool, error) {
+		mh, err := multihash.FromB58String(key.BaseNamespace())
+		if err != nil {
+			return false, err
+		}
+		var result storedResult
+		if err := json.Unmarshal(v, &result); err != nil {
+			return false, err
+		}
+		if time.Now().After(result.Expiry) {
+			return false, nil
+		}
+		f(mh, &result)
+		return true, nil
+	})
+}
+
+// forEachPeer calls f for every unexpired stored peer, and deletes the expired ones.
+func (s *resultStore) forEachPeer(ctx context.Context, f func(*storedPeer)) error {
+	return s.forEach(ctx, resultStorePeersPrefix, func(_ datastore.Key, v []byte) (bool, error) {
+		var p storedPeer
+		if err := json.Unmarshal(v, &p); err != nil {
+			return false, err
+		}
+		if time.Now().After(p.Expiry) {
+			return false, nil
+		}
+		f(&p)
+		return true, nil
+	})
+}
+
+// forEach iterates over the entries with the given prefix and deletes the ones for which f
+// returns false or fails to process.
+func (s *resultStore) forEach(ctx context.Context, prefix datastore.Key, f func(datastore.Key, []byte) (bool, error)) error {
+	results, err := s.ds.Query(ctx, query.Query{Prefix: prefix.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	var expired []datastore.Key
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		key := datastore.NewKey(r.Key)
+		keep, err := f(key, r.Value)
+		if err != nil {
+			logger.Warnw("Failed to process stored entry; deleting it", "key", key, "err", err)
+		}
+		if !keep {
+			expired = append(expired, key)
+		}
+	}
+	for _, key := range expired {
+		if err := s.ds.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
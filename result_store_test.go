@@ -0,0 +1,73 @@
+package caskadht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resultStore(t *testing.T) {
+	ctx := context.Background()
+	fresh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	expired, err := multihash.Sum([]byte("lobster"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	pid, err := peer.Decode("12D3KooWSnniGsyAF663gvHdqhyfJMCjWJv54cGSzcPiEMAfanvU")
+	require.NoError(t, err)
+	now := time.Now()
+
+	subject := newResultStore(sync.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, subject.putProviders(ctx, fresh, &storedResult{
+		Providers: []peer.AddrInfo{{ID: pid}},
+		At:        now,
+		Expiry:    now.Add(time.Hour),
+	}))
+	require.NoError(t, subject.putProviders(ctx, expired, &storedResult{
+		At:     now.Add(-time.Hour),
+		Expiry: now.Add(-time.Minute),
+	}))
+	require.NoError(t, subject.putPeer(ctx, &storedPeer{
+		AddrInfo: peer.AddrInfo{ID: pid},
+		Expiry:   now.Add(time.Hour),
+	}))
+
+	got, found, err := subject.getProviders(ctx, fresh)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, pid, got.Providers[0].ID)
+	require.True(t, now.Equal(got.At))
+
+	var loaded []multihash.Multihash
+	require.NoError(t, subject.forEachProviders(ctx, func(key multihash.Multihash, _ *storedResult) {
+		loaded = append(loaded, key)
+	}))
+	require.Equal(t, []multihash.Multihash{fresh}, loaded)
+
+	// Expired entries must have been removed while iterating.
+	has, err := subject.ds.Has(ctx, subject.providersKey(expired))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	var loadedPeers []peer.ID
+	require.NoError(t, subject.forEachPeer(ctx, func(p *storedPeer) {
+		loadedPeers = append(loadedPeers, p.AddrInfo.ID)
+	}))
+	require.Equal(t, []peer.ID{pid}, loadedPeers)
+}
+
+func Test_WithDatastoreRequiresResultCache(t *testing.T) {
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	_, err := newOptions(WithDatastore(ds))
+	require.ErrorContains(t, err, "datastore requires the provider result cache")
+
+	opts, err := newOptions(WithDatastore(ds), WithResultCacheMaxSize(10))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = opts.h.Close() })
+	require.Equal(t, ds, opts.ds)
+}
@@ -0,0 +1,154 @@
+package caskadht
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// DHTClientSelection specifies how the DHT client used for provider lookups is selected when the
+// accelerated DHT client is enabled.
+// See: WithUseAcceleratedDHT.
+type DHTClientSelection string
+
+const (
+	// DHTClientSelectionReady uses the accelerated DHT client when it is ready, and the standard
+	// DHT client otherwise.
+	DHTClientSelectionReady DHTClientSelection = "ready"
+	// DHTClientSelectionRace queries both the standard and the accelerated DHT clients
+	// concurrently when the accelerated client is ready, merging their results.
+	DHTClientSelectionRace DHTClientSelection = "race"
+	// DHTClientSelectionAdaptive uses whichever of the standard and the accelerated DHT clients
+	// currently performs better, based on their recent time to first provider and success rate.
+	// A small fraction of lookups are sent to the other client in order to keep track of its
+	// performance.
+	// See: WithDHTClientAdaptiveWindow, WithDHTClientAdaptiveExploration.
+	DHTClientSelectionAdaptive DHTClientSelection = "adaptive"
+)
+
+const (
+	dhtClientStd = "std"
+	dhtClientAcc = "acc"
+)
+
+func (s DHTClientSelection) validate() error {
+	switch s {
+	case DHTClientSelectionReady, DHTClientSelectionRace, DHTClientSelectionAdaptive:
+		return nil
+	default:
+		return fmt.Errorf("unknown DHT client selection: %s", s)
+	}
+}
+
+// findProvidersAsync finds the providers of the given key using the DHT client(s) of the given
+// backend, selected according to the configured DHTClientSelection.
+func (c *Caskadht) findProvidersAsync(ctx context.Context, b *dhtBackend, key cid.Cid) <-chan peer.AddrInfo {
+	if b.useAccDHT {
+		switch c.dhtClientSelection {
+		case DHTClientSelectionRace:
+			if b.acc.Ready() {
+				return c.raceFindProviders(ctx, b, key)
+			}
+		case DHTClientSelectionAdaptive:
+			return c.adaptiveFindProviders(ctx, b, key)
+		}
+	}
+	return b.routing().FindProvidersAsync(ctx, key, c.findProvidersLimit)
+}
+
+// adaptiveFindProviders finds providers using the DHT client chosen by the selector, and records
+// the outcome of the lookup in order to inform future selections.
+func (c *Caskadht) adaptiveFindProviders(ctx context.Context, b *dhtBackend, key cid.Cid) <-chan peer.AddrInfo {
+	name, reason := b.selector.selectClient(b.acc.Ready())
+	c.metrics.notifyDHTClientSelected(ctx, name, reason)
+	var client routing.Routing = b.std
+	if name == dhtClientAcc {
+		client = b.acc
+	}
+	start := time.Now()
+	rch := make(chan peer.AddrInfo, 1)
+	go func() {
+		defer close(rch)
+		var sample dhtClientSample
+		for provider := range client.FindProvidersAsync(ctx, key, c.findProvidersLimit) {
+			if !sample.success {
+				sample.success = true
+				sample.timeToFirstProvider = time.Since(start)
+				c.metrics.notifyDHTClientFirstProvider(ctx, name, sample.timeToFirstProvider)
+			}
+			select {
+			case <-ctx.Done():
+			case rch <- provider:
+			}
+		}
+		// Lookups interrupted before finding any providers say nothing about the client's
+		// performance; skip them.
+		if sample.success || ctx.Err() == nil {
+			b.selector.stats(name).record(sample)
+		}
+	}()
+	return rch
+}
+
+// raceFindProviders queries both the standard and the accelerated DHT clients concurrently, and
+// merges their results deduplicated by peer ID. Each provider is attributed to the client that
+// found it first.
+func (c *Caskadht) raceFindProviders(ctx context.Context, b *dhtBackend, key cid.Cid) <-chan peer.AddrInfo {
+	ctx, cancel := context.WithCancel(ctx)
+	rch := make(chan peer.AddrInfo, 1)
+	var lock sync.Mutex
+	seen := make(map[peer.ID]struct{})
+	var wg sync.WaitGroup
+	start := time.Now()
+	race := func(name string, client routing.Routing) {
+		defer wg.Done()
+		var first bool
+		for provider := range client.FindProvidersAsync(ctx, key, c.findProvidersLimit) {
+			lock.Lock()
+			_, found := seen[provider.ID]
+			limited := c.findProvidersLimit > 0 && len(seen) >= c.findProvidersLimit
+			if !found && !limited {
+				seen[provider.ID] = struct{}{}
+			}
+			lock.Unlock()
+			if limited {
+				cancel()
+				return
+			}
+			if found {
+				continue
+			}
+			if !first {
+				first = true
+				c.metrics.notifyDHTClientFirstProvider(ctx, name, time.Since(start))
+			}
+			c.metrics.notifyDHTClientProviderFound(ctx, name)
+			select {
+			case <-ctx.Done():
+				return
+			case rch <- provider:
+			}
+		}
+	}
+	wg.Add(2)
+	go race(dhtClientStd, b.std)
+	go race(dhtClientAcc, b.acc)
+	go func() {
+		wg.Wait()
+		cancel()
+		close(rch)
+	}()
+	return rch
+}
+
+func (b *dhtBackend) routing() routing.Routing {
+	if b.useAccDHT && b.acc.Ready() {
+		return b.acc
+	}
+	return b.std
+}
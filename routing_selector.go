@@ -0,0 +1,122 @@
+package caskadht
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	dhtClientSelectReasonNotReady = "not_ready"
+	dhtClientSelectReasonNoData   = "no_data"
+	dhtClientSelectReasonExplore  = "explore"
+	dhtClientSelectReasonExploit  = "exploit"
+)
+
+type (
+	// dhtClientSelector selects between the standard and the accelerated DHT clients based on
+	// their recent lookup performance.
+	dhtClientSelector struct {
+		std         *dhtClientStats
+		acc         *dhtClientStats
+		exploration float64
+	}
+	// dhtClientStats tracks the outcome of the most recent lookups by a DHT client over a sliding
+	// window.
+	dhtClientStats struct {
+		lock    sync.Mutex
+		samples []dhtClientSample
+		next    int
+		count   int
+	}
+	dhtClientSample struct {
+		success             bool
+		timeToFirstProvider time.Duration
+	}
+)
+
+func newDHTClientSelector(window int, exploration float64) (*dhtClientSelector, error) {
+	if window < 1 {
+		return nil, errors.New("adaptive window must be at least 1")
+	}
+	return &dhtClientSelector{
+		std:         newDHTClientStats(window),
+		acc:         newDHTClientStats(window),
+		exploration: exploration,
+	}, nil
+}
+
+func newDHTClientStats(window int) *dhtClientStats {
+	return &dhtClientStats{
+		samples: make([]dhtClientSample, window),
+	}
+}
+
+// selectClient returns the name of DHT client to use for the next lookup along with the reason
+// it was selected. The better performing client is selected, except for a fraction of lookups
+// determined by the exploration rate, which are sent to the other client so that its stats stay
+// current.
+func (s *dhtClientSelector) selectClient(accReady bool) (string, string) {
+	if !accReady {
+		return dhtClientStd, dhtClientSelectReasonNotReady
+	}
+	stdScore, stdOk := s.std.score()
+	accScore, accOk := s.acc.score()
+	switch {
+	case !accOk:
+		return dhtClientAcc, dhtClientSelectReasonNoData
+	case !stdOk:
+		return dhtClientStd, dhtClientSelectReasonNoData
+	}
+	best, other := dhtClientAcc, dhtClientStd
+	if stdScore < accScore {
+		best, other = other, best
+	}
+	if rand.Float64() < s.exploration {
+		return other, dhtClientSelectReasonExplore
+	}
+	return best, dhtClientSelectReasonExploit
+}
+
+func (s *dhtClientSelector) stats(client string) *dhtClientStats {
+	if client == dhtClientAcc {
+		return s.acc
+	}
+	return s.std
+}
+
+func (s *dhtClientStats) record(sample dhtClientSample) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % len(s.samples)
+	if s.count < len(s.samples) {
+		s.count++
+	}
+}
+
+// score returns the expected time to first provider, i.e. the mean time to first provider of
+// successful lookups divided by the success rate; lower is better. False is returned if there are
+// no samples.
+func (s *dhtClientStats) score() (time.Duration, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.count == 0 {
+		return 0, false
+	}
+	var successes int
+	var total time.Duration
+	for _, sample := range s.samples[:s.count] {
+		if sample.success {
+			successes++
+			total += sample.timeToFirstProvider
+		}
+	}
+	if successes == 0 {
+		// Treat a client that had no successful lookups as the worst possible.
+		return time.Duration(1<<63 - 1), true
+	}
+	mean := total / time.Duration(successes)
+	return mean * time.Duration(s.count) / time.Duration(successes), true
+}
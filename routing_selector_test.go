@@ -0,0 +1,49 @@
+package caskadht
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_dhtClientSelector(t *testing.T) {
+	_, err := newDHTClientSelector(0, 0)
+	require.Error(t, err)
+	subject, err := newDHTClientSelector(2, 0)
+	require.NoError(t, err)
+
+	client, reason := subject.selectClient(false)
+	require.Equal(t, dhtClientStd, client)
+	require.Equal(t, dhtClientSelectReasonNotReady, reason)
+
+	client, reason = subject.selectClient(true)
+	require.Equal(t, dhtClientAcc, client)
+	require.Equal(t, dhtClientSelectReasonNoData, reason)
+	subject.acc.record(dhtClientSample{success: true, timeToFirstProvider: 100 * time.Millisecond})
+
+	client, reason = subject.selectClient(true)
+	require.Equal(t, dhtClientStd, client)
+	require.Equal(t, dhtClientSelectReasonNoData, reason)
+	subject.std.record(dhtClientSample{success: true, timeToFirstProvider: 150 * time.Millisecond})
+
+	client, reason = subject.selectClient(true)
+	require.Equal(t, dhtClientAcc, client)
+	require.Equal(t, dhtClientSelectReasonExploit, reason)
+
+	// A failed lookup halves the success rate of the accelerated client, making it worse.
+	subject.acc.record(dhtClientSample{})
+	client, _ = subject.selectClient(true)
+	require.Equal(t, dhtClientStd, client)
+
+	// Only the most recent samples within the window must be considered.
+	subject.acc.record(dhtClientSample{success: true, timeToFirstProvider: 50 * time.Millisecond})
+	subject.acc.record(dhtClientSample{success: true, timeToFirstProvider: 50 * time.Millisecond})
+	client, _ = subject.selectClient(true)
+	require.Equal(t, dhtClientAcc, client)
+
+	subject.exploration = 1
+	client, reason = subject.selectClient(true)
+	require.Equal(t, dhtClientStd, client)
+	require.Equal(t, dhtClientSelectReasonExplore, reason)
+}
@@ -0,0 +1,195 @@
+package caskadht
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"gopkg.in/yaml.v3"
+)
+
+// staticPrefixWildcard marks a static providers key as a prefix of CID or base58 multihash
+// strings, e.g. "bafybei*".
+const staticPrefixWildcard = "*"
+
+type (
+	// staticProviders serves fixed providers for multihashes or CID prefixes, loaded from a JSON
+	// or YAML file, e.g.:
+	//
+	//	{
+	//	  "QmPNHBy5h7f19yJDt7ip9TvmMRbqmYsa6aetkrsc1ghjLB": [
+	//	    {"ID": "12D3KooW...", "Addrs": ["/ip4/1.2.3.4/tcp/4001"]}
+	//	  ],
+	//	  "bafybei*": [
+	//	    {"ID": "12D3KooW...", "Addrs": ["/dns4/example.com/tcp/443/wss"]}
+	//	  ]
+	//	}
+	//
+	// Keys are either multihashes in base58, CIDs, or prefixes of either suffixed with "*".
+	staticProviders struct {
+		path string
+
+		lock     sync.RWMutex
+		modTime  time.Time
+		exact    map[string][]peer.AddrInfo
+		prefixes []staticPrefix
+	}
+	staticPrefix struct {
+		prefix    string
+		providers []peer.AddrInfo
+	}
+	staticProvider struct {
+		ID    string   `json:"ID" yaml:"ID"`
+		Addrs []string `json:"Addrs" yaml:"Addrs"`
+	}
+)
+
+func newStaticProviders(path string) *staticProviders {
+	return &staticProviders{
+		path: path,
+	}
+}
+
+// load (re)loads the static providers from file. The currently loaded providers are kept if the
+// file cannot be loaded.
+func (s *staticProviders) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var entries map[string][]staticProvider
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &entries)
+	default:
+		err = json.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		return err
+	}
+
+	exact := make(map[string][]peer.AddrInfo)
+	var prefixes []staticPrefix
+	for key, entry := range entries {
+		providers, err := parseStaticProviders(entry)
+		if err != nil {
+			return fmt.Errorf("invalid static providers for %s: %w", key, err)
+		}
+		if prefix, isPrefix := strings.CutSuffix(key, staticPrefixWildcard); isPrefix {
+			prefixes = append(prefixes, staticPrefix{prefix: prefix, providers: providers})
+			continue
+		}
+		mh, err := staticKeyMultihash(key)
+		if err != nil {
+			return fmt.Errorf("invalid static providers key %s: %w", key, err)
+		}
+		exact[string(mh)] = append(exact[string(mh)], providers...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.modTime = info.ModTime()
+	s.exact = exact
+	s.prefixes = prefixes
+	logger.Infow("Loaded static providers", "path", s.path, "keys", len(exact), "prefixes", len(prefixes))
+	return nil
+}
+
+// reloadIfChanged reloads the static providers if the file has been modified since last loaded.
+func (s *staticProviders) reloadIfChanged() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.lock.RLock()
+	changed := !info.ModTime().Equal(s.modTime)
+	s.lock.RUnlock()
+	if !changed {
+		return nil
+	}
+	return s.load()
+}
+
+// get returns the static providers of the given key, including the providers of any matching
+// prefixes.
+func (s *staticProviders) get(key cid.Cid) []peer.AddrInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	providers := s.exact[string(key.Hash())]
+	if len(s.prefixes) == 0 {
+		return providers
+	}
+	cidStr := key.String()
+	mhStr := key.Hash().B58String()
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(cidStr, p.prefix) || strings.HasPrefix(mhStr, p.prefix) {
+			providers = append(providers[:len(providers):len(providers)], p.providers...)
+		}
+	}
+	return providers
+}
+
+func staticKeyMultihash(key string) (multihash.Multihash, error) {
+	if c, err := cid.Decode(key); err == nil {
+		return c.Hash(), nil
+	}
+	return multihash.FromB58String(key)
+}
+
+func parseStaticProviders(entries []staticProvider) ([]peer.AddrInfo, error) {
+	providers := make([]peer.AddrInfo, 0, len(entries))
+	for _, entry := range entries {
+		id, err := peer.Decode(entry.ID)
+		if err != nil {
+			return nil, err
+		}
+		provider := peer.AddrInfo{ID: id}
+		for _, a := range entry.Addrs {
+			addr, err := multiaddr.NewMultiaddr(a)
+			if err != nil {
+				return nil, err
+			}
+			provider.Addrs = append(provider.Addrs, addr)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// ReloadStaticProviders reloads the static providers from file, if configured.
+// See: WithStaticProvidersPath.
+func (c *Caskadht) ReloadStaticProviders() error {
+	if c.static == nil {
+		return nil
+	}
+	return c.static.load()
+}
+
+// watchStaticProviders periodically reloads the static providers if the file has changed, until
+// the server is shut down.
+func (c *Caskadht) watchStaticProviders() {
+	ticker := time.NewTicker(c.staticProvidersCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.static.reloadIfChanged(); err != nil {
+				logger.Errorw("Failed to reload static providers", "path", c.static.path, "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package caskadht
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_staticProviders(t *testing.T) {
+	fish, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	lobster, err := multihash.Sum([]byte("lobster"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	fishCid := cid.NewCidV1(cid.Raw, fish)
+	lobsterCid := cid.NewCidV1(cid.DagProtobuf, lobster)
+	p1 := test.RandPeerIDFatal(t)
+	p2 := test.RandPeerIDFatal(t)
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "static.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(fmt.Sprintf(`{
+  %q: [{"ID": %q, "Addrs": ["/ip4/1.2.3.4/tcp/4001"]}],
+  "bafk*": [{"ID": %q}]
+}`, fish.B58String(), p1, p2)), 0o600))
+
+	subject := newStaticProviders(jsonPath)
+	require.NoError(t, subject.load())
+	got := subject.get(fishCid)
+	require.Len(t, got, 2)
+	require.Equal(t, p1, got[0].ID)
+	require.Len(t, got[0].Addrs, 1)
+	require.Equal(t, p2, got[1].ID)
+	// CIDv1 with dag-pb codec starts with "bafy", which must not match the "bafk" prefix.
+	require.Empty(t, subject.get(lobsterCid))
+
+	// Change the file and assert it is reloaded.
+	require.NoError(t, os.WriteFile(jsonPath, []byte(fmt.Sprintf(`{%q: [{"ID": %q}]}`, lobsterCid, p2)), 0o600))
+	require.NoError(t, os.Chtimes(jsonPath, time.Now(), time.Now().Add(time.Minute)))
+	require.NoError(t, subject.reloadIfChanged())
+	require.Empty(t, subject.get(fishCid))
+	require.Equal(t, []peer.AddrInfo{{ID: p2}}, subject.get(lobsterCid))
+
+	// Invalid files must not replace the loaded providers.
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"fish": [{"ID": "lobster"}]}`), 0o600))
+	require.NoError(t, os.Chtimes(jsonPath, time.Now(), time.Now().Add(2*time.Minute)))
+	require.Error(t, subject.reloadIfChanged())
+	require.Equal(t, []peer.AddrInfo{{ID: p2}}, subject.get(lobsterCid))
+
+	yamlPath := filepath.Join(dir, "static.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(fmt.Sprintf(`%s:
+  - ID: %s
+    Addrs:
+      - /ip4/1.2.3.4/tcp/4001
+`, fishCid, p1)), 0o600))
+	subject = newStaticProviders(yamlPath)
+	require.NoError(t, subject.load())
+	got = subject.get(fishCid)
+	require.Len(t, got, 1)
+	require.Equal(t, p1, got[0].ID)
+}
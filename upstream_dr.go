@@ -0,0 +1,203 @@
+package caskadht
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	mediaTypeJson   = "application/json"
+	mediaTypeNDJson = "application/x-ndjson"
+)
+
+type (
+	// drUpstream is an upstream delegated routing HTTP endpoint to which lookups are fanned out
+	// in addition to the DHT.
+	drUpstream struct {
+		url     string
+		timeout time.Duration
+		client  *http.Client
+	}
+	// drUpstreamRecord is a provider record in an upstream delegated routing response. Unlike
+	// drProviderRecord, addrs are kept as strings so that a single malformed addr does not fail
+	// the decoding of the entire record.
+	drUpstreamRecord struct {
+		Schema string
+		ID     *peer.ID
+		Addrs  []string
+	}
+	drUpstreamRecords struct {
+		Providers []drUpstreamRecord
+	}
+)
+
+func newDrUpstream(url string, timeout time.Duration) *drUpstream {
+	return &drUpstream{
+		url:     strings.TrimSuffix(url, "/"),
+		timeout: timeout,
+		client:  http.DefaultClient,
+	}
+}
+
+// findProviders finds the providers of the given key from the upstream, calling f for each
+// provider found until f returns false. A response with status 404 Not Found is treated as no
+// providers.
+func (u *drUpstream) findProviders(ctx context.Context, key cid.Cid, f func(peer.AddrInfo) bool) error {
+	if u.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url+"/routing/v1/providers/"+key.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", mediaTypeNDJson+", "+mediaTypeJson)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("unsuccessful upstream response: %d", resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	dec := json.NewDecoder(resp.Body)
+	if mediaType != mediaTypeNDJson {
+		var records drUpstreamRecords
+		if err := dec.Decode(&records); err != nil {
+			return err
+		}
+		for _, record := range records.Providers {
+			if provider, ok := record.addrInfo(); ok && !f(provider) {
+				return nil
+			}
+		}
+		return nil
+	}
+	for {
+		var record drUpstreamRecord
+		if err := dec.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if provider, ok := record.addrInfo(); ok && !f(provider) {
+			return nil
+		}
+	}
+}
+
+func (r *drUpstreamRecord) addrInfo() (peer.AddrInfo, bool) {
+	if r.ID == nil {
+		return peer.AddrInfo{}, false
+	}
+	provider := peer.AddrInfo{ID: *r.ID}
+	for _, s := range r.Addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			logger.Debugw("Skipping invalid upstream provider addr", "id", provider.ID, "addr", s, "err", err)
+			continue
+		}
+		provider.Addrs = append(provider.Addrs, addr)
+	}
+	return provider, true
+}
+
+// upstreamFindProviders finds the providers of the given key from the given upstream in the
+// background, recording the upstream metrics.
+func (c *Caskadht) upstreamFindProviders(ctx context.Context, u *drUpstream, key cid.Cid) <-chan peer.AddrInfo {
+	rch := make(chan peer.AddrInfo, 1)
+	go func() {
+		defer close(rch)
+		start := time.Now()
+		var count int64
+		err := u.findProviders(ctx, key, func(provider peer.AddrInfo) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case rch <- provider:
+				count++
+				return true
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			logger.Warnw("Failed to find providers from upstream", "upstream", u.url, "key", key, "err", err)
+		}
+		c.metrics.notifyUpstreamResponded(context.Background(), u.url, count, time.Since(start), err)
+	}()
+	return rch
+}
+
+// mergeProviders merges the providers from the given channels, deduplicated by peer ID, up to the
+// given limit if positive. The returned channel is closed once all the given channels are closed
+// or the context is done.
+func mergeProviders(ctx context.Context, limit int, chs ...<-chan peer.AddrInfo) <-chan peer.AddrInfo {
+	ctx, cancel := context.WithCancel(ctx)
+	rch := make(chan peer.AddrInfo, 1)
+	var lock sync.Mutex
+	seen := make(map[peer.ID]struct{})
+	var wg sync.WaitGroup
+	merge := func(ch <-chan peer.AddrInfo) {
+		defer wg.Done()
+		for {
+			var provider peer.AddrInfo
+			select {
+			case <-ctx.Done():
+				return
+			case p, ok := <-ch:
+				if !ok {
+					return
+				}
+				provider = p
+			}
+			lock.Lock()
+			_, found := seen[provider.ID]
+			limited := limit > 0 && len(seen) >= limit
+			if !found && !limited {
+				seen[provider.ID] = struct{}{}
+			}
+			lock.Unlock()
+			if limited {
+				cancel()
+				return
+			}
+			if found {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case rch <- provider:
+			}
+		}
+	}
+	wg.Add(len(chs))
+	for _, ch := range chs {
+		go merge(ch)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(rch)
+	}()
+	return rch
+}
@@ -0,0 +1,117 @@
+package caskadht
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_drUpstream(t *testing.T) {
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	key := cid.NewCidV1(cid.Raw, mh)
+	p1 := test.RandPeerIDFatal(t)
+	p2 := test.RandPeerIDFatal(t)
+	addr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		timeout time.Duration
+		want    []peer.AddrInfo
+		wantErr bool
+	}{
+		{
+			name: "json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/routing/v1/providers/"+key.String(), r.URL.Path)
+				w.Header().Set("Content-Type", mediaTypeJson)
+				_, _ = fmt.Fprintf(w, `{"Providers":[{"Schema":"bitswap","Protocol":"transport-bitswap","ID":%q,"Addrs":[%q,"fish"]},{"Schema":"peer","ID":%q,"Addrs":[]}]}`, p1, addr, p2)
+			},
+			want: []peer.AddrInfo{{ID: p1, Addrs: []multiaddr.Multiaddr{addr}}, {ID: p2}},
+		},
+		{
+			name: "ndjson",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", mediaTypeNDJson)
+				_, _ = fmt.Fprintf(w, "{\"Schema\":\"peer\",\"ID\":%q,\"Addrs\":[%q]}\n{\"Schema\":\"unknown\"}\n{\"Schema\":\"peer\",\"ID\":%q}\n", p1, addr, p2)
+			},
+			want: []peer.AddrInfo{{ID: p1, Addrs: []multiaddr.Multiaddr{addr}}, {ID: p2}},
+		},
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "", http.StatusNotFound)
+			},
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "", http.StatusInternalServerError)
+			},
+			wantErr: true,
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			timeout: 100 * time.Millisecond,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			t.Cleanup(server.Close)
+			u := newDrUpstream(server.URL+"/", tt.timeout)
+			var got []peer.AddrInfo
+			err := u.findProviders(context.Background(), key, func(provider peer.AddrInfo) bool {
+				got = append(got, provider)
+				return true
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_mergeProviders(t *testing.T) {
+	p1 := test.RandPeerIDFatal(t)
+	p2 := test.RandPeerIDFatal(t)
+	p3 := test.RandPeerIDFatal(t)
+	source := func(ids ...peer.ID) <-chan peer.AddrInfo {
+		ch := make(chan peer.AddrInfo, len(ids))
+		for _, id := range ids {
+			ch <- peer.AddrInfo{ID: id}
+		}
+		close(ch)
+		return ch
+	}
+
+	seen := make(map[peer.ID]int)
+	for provider := range mergeProviders(context.Background(), 0, source(p1, p2), source(p2, p3), source(p1)) {
+		seen[provider.ID]++
+	}
+	require.Equal(t, map[peer.ID]int{p1: 1, p2: 1, p3: 1}, seen)
+
+	var count int
+	for range mergeProviders(context.Background(), 2, source(p1, p2), source(p2, p3)) {
+		count++
+	}
+	require.LessOrEqual(t, count, 2)
+}
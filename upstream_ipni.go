@@ -0,0 +1,204 @@
+package caskadht
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/ipfs/go-cid"
+	findclient "github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+type (
+	// ipniUpstream is an upstream IPNI indexer to which lookups are fanned out in addition to the
+	// DHT. Unlike the DHT, the results of IPNI upstreams carry their original context ID and
+	// metadata.
+	ipniUpstream struct {
+		url     string
+		timeout time.Duration
+		client  *findclient.Client
+	}
+	// ipniResultCache caches the provider results of IPNI upstreams, which expire after the
+	// result cache max age, or negative max age when there are no results.
+	ipniResultCache struct {
+		lock           sync.Mutex
+		lru            *lru.Cache
+		maxAge         time.Duration
+		negativeMaxAge time.Duration
+	}
+	cachedIpniResult struct {
+		results []model.ProviderResult
+		at      time.Time
+	}
+)
+
+func newIpniUpstream(url string, timeout time.Duration) (*ipniUpstream, error) {
+	client, err := findclient.New(url, findclient.WithClient(http.DefaultClient))
+	if err != nil {
+		return nil, err
+	}
+	return &ipniUpstream{
+		url:     strings.TrimSuffix(url, "/"),
+		timeout: timeout,
+		client:  client,
+	}, nil
+}
+
+// findProviders finds the provider results of the given multihash from the upstream.
+func (u *ipniUpstream) findProviders(ctx context.Context, mh multihash.Multihash) ([]model.ProviderResult, error) {
+	if u.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.timeout)
+		defer cancel()
+	}
+	resp, err := u.client.Find(ctx, mh)
+	if err != nil {
+		return nil, err
+	}
+	var results []model.ProviderResult
+	for _, mhr := range resp.MultihashResults {
+		if bytes.Equal(mhr.Multihash, mh) {
+			results = append(results, mhr.ProviderResults...)
+		}
+	}
+	return results, nil
+}
+
+func newIpniResultCache(maxEntries int, maxAge, negativeMaxAge time.Duration) *ipniResultCache {
+	return &ipniResultCache{
+		lru:            lru.New(maxEntries),
+		maxAge:         maxAge,
+		negativeMaxAge: negativeMaxAge,
+	}
+}
+
+// get returns the unexpired cached results of the given key, if any.
+func (p *ipniResultCache) get(key string) ([]model.ProviderResult, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	v, found := p.lru.Get(key)
+	if !found {
+		return nil, false
+	}
+	if result, ok := v.(*cachedIpniResult); ok && result != nil {
+		maxAge := p.maxAge
+		if len(result.results) == 0 {
+			maxAge = p.negativeMaxAge
+		}
+		if time.Since(result.at) < maxAge {
+			return result.results, true
+		}
+	}
+	p.lru.Remove(key)
+	return nil, false
+}
+
+func (p *ipniResultCache) put(key string, results []model.ProviderResult) {
+	if len(results) == 0 && p.negativeMaxAge <= 0 {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lru.Add(key, &cachedIpniResult{
+		results: results,
+		at:      time.Now(),
+	})
+}
+
+// ipniUpstreamLookup finds the provider results of the given multihash from the given upstream.
+// Like DHT lookups, results are served from the IPNI result cache if enabled, and concurrent
+// lookups for the same multihash are coalesced into a single upstream request.
+func (c *Caskadht) ipniUpstreamLookup(ctx context.Context, u *ipniUpstream, mh multihash.Multihash) ([]model.ProviderResult, error) {
+	key := u.url + "/" + mh.B58String()
+	if c.ipniResults != nil {
+		if results, found := c.ipniResults.get(key); found {
+			return results, nil
+		}
+	}
+	// The shared request outlives individual requests and is only cancelled on shutdown.
+	rch := c.ipniLookups.DoChan(key, func() (any, error) {
+		start := time.Now()
+		results, err := u.findProviders(c.ctx, mh)
+		c.metrics.notifyUpstreamResponded(context.Background(), u.url, int64(len(results)), time.Since(start), err)
+		if err == nil && c.ipniResults != nil {
+			c.ipniResults.put(key, results)
+		}
+		return results, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-rch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.([]model.ProviderResult), nil
+	}
+}
+
+// ipniUpstreamFindProviders finds the provider results of the given key from all IPNI upstreams
+// concurrently, deduplicated by provider ID and context ID. Returns nil if there are no IPNI
+// upstreams.
+func (c *Caskadht) ipniUpstreamFindProviders(ctx context.Context, key cid.Cid) <-chan model.ProviderResult {
+	if len(c.ipniUpstreams) == 0 {
+		return nil
+	}
+	rch := make(chan model.ProviderResult, 1)
+	var lock sync.Mutex
+	type resultKey struct {
+		id        peer.ID
+		contextID string
+	}
+	seen := make(map[resultKey]struct{})
+	var wg sync.WaitGroup
+	find := func(u *ipniUpstream) {
+		defer wg.Done()
+		results, err := c.ipniUpstreamLookup(ctx, u, key.Hash())
+		if err != nil && ctx.Err() == nil {
+			logger.Warnw("Failed to find providers from IPNI upstream", "upstream", u.url, "key", key, "err", err)
+		}
+		for _, result := range results {
+			if result.Provider == nil || result.Provider.ID.Validate() != nil || !c.allowedProvider(ctx, result.Provider.ID) {
+				continue
+			}
+			// Apply the same addr filtering as the providers found on the DHT.
+			result.Provider = &peer.AddrInfo{
+				ID:    result.Provider.ID,
+				Addrs: c.filterAddrs(result.Provider.Addrs),
+			}
+			if len(result.Provider.Addrs) == 0 {
+				logger.Debugw("Found no addrs that pass the addr filter for IPNI upstream provider; skipping result", "id", result.Provider.ID)
+				continue
+			}
+			rk := resultKey{id: result.Provider.ID, contextID: string(result.ContextID)}
+			lock.Lock()
+			_, found := seen[rk]
+			seen[rk] = struct{}{}
+			lock.Unlock()
+			if found {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case rch <- result:
+			}
+		}
+	}
+	wg.Add(len(c.ipniUpstreams))
+	for _, u := range c.ipniUpstreams {
+		go find(u)
+	}
+	go func() {
+		wg.Wait()
+		close(rch)
+	}()
+	return rch
+}
@@ -0,0 +1,117 @@
+package caskadht
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ipniUpstream(t *testing.T) {
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	want := model.ProviderResult{
+		ContextID: []byte("lobster"),
+		Metadata:  []byte("barreleye"),
+		Provider: &peer.AddrInfo{
+			ID:    test.RandPeerIDFatal(t),
+			Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")},
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/multihash/"+mh.B58String(), r.URL.Path)
+		body, err := model.MarshalFindResponse(&model.FindResponse{
+			MultihashResults: []model.MultihashResult{{Multihash: mh, ProviderResults: []model.ProviderResult{want}}},
+		})
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", mediaTypeJson)
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := newIpniUpstream(server.URL, 0)
+	require.NoError(t, err)
+	got, err := u.findProviders(context.Background(), mh)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, want.ContextID, got[0].ContextID)
+	require.Equal(t, want.Metadata, got[0].Metadata)
+	require.Equal(t, want.Provider.ID, got[0].Provider.ID)
+	require.Equal(t, want.Provider.Addrs, got[0].Provider.Addrs)
+}
+
+func Test_ipniResultCache(t *testing.T) {
+	want := []model.ProviderResult{{
+		ContextID: []byte("lobster"),
+		Provider:  &peer.AddrInfo{ID: test.RandPeerIDFatal(t)},
+	}}
+
+	subject := newIpniResultCache(2, 50*time.Millisecond, 0)
+	subject.put("fish", want)
+	// Empty results must not be cached when negative caching is disabled.
+	subject.put("crab", nil)
+
+	got, found := subject.get("fish")
+	require.True(t, found)
+	require.Equal(t, want, got)
+	_, found = subject.get("crab")
+	require.False(t, found)
+
+	time.Sleep(50 * time.Millisecond)
+	_, found = subject.get("fish")
+	require.False(t, found)
+
+	subject = newIpniResultCache(2, time.Hour, time.Hour)
+	subject.put("crab", nil)
+	got, found = subject.get("crab")
+	require.True(t, found)
+	require.Empty(t, got)
+}
+
+func Test_writeIpniProviderResult(t *testing.T) {
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	key := cid.NewCidV1(cid.Raw, mh)
+	pieceCid := cid.NewCidV1(cid.Raw, mh)
+	md := metadata.Default.New(metadata.Bitswap{}, &metadata.GraphsyncFilecoinV1{PieceCID: pieceCid, VerifiedDeal: true})
+	mdBytes, err := md.MarshalBinary()
+	require.NoError(t, err)
+	provider := peer.AddrInfo{
+		ID:    test.RandPeerIDFatal(t),
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/routing/v1/providers/"+key.String(), nil)
+	r.Header.Set("Accept", mediaTypeJson)
+	rec := httptest.NewRecorder()
+	w, err := newDelegatedRoutingLookupResponseWriter(rec, r, false, DelegatedRoutingSchemaBitswap)
+	require.NoError(t, err)
+	require.NoError(t, w.writeIpniProviderResult(model.ProviderResult{Metadata: mdBytes, Provider: &provider}))
+	// The same provider found on the DHT must not be duplicated.
+	require.NoError(t, w.writeDrProviderRecord(provider))
+	require.NoError(t, w.close())
+
+	var got struct {
+		Providers []map[string]any
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	require.Len(t, got.Providers, 2)
+	require.Equal(t, "transport-bitswap", got.Providers[0]["Protocol"])
+	require.Equal(t, "bitswap", got.Providers[0]["Schema"])
+	require.NotContains(t, got.Providers[0], "PieceCID")
+	require.Equal(t, "transport-graphsync-filecoinv1", got.Providers[1]["Protocol"])
+	require.Equal(t, "graphsync-filecoinv1", got.Providers[1]["Schema"])
+	require.Equal(t, map[string]any{"/": pieceCid.String()}, got.Providers[1]["PieceCID"])
+	require.Equal(t, true, got.Providers[1]["VerifiedDeal"])
+}